@@ -0,0 +1,109 @@
+package clipd
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// AddressSet is a list of IP prefixes, built from addresses or CIDR ranges.
+type AddressSet []netip.Prefix
+
+func ParseAddressSet(entries []string) (AddressSet, error) {
+	set := make(AddressSet, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := parseAddressEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, prefix)
+	}
+	return set, nil
+}
+
+// ResolveAddressSet is like ParseAddressSet but also accepts hostnames, which are resolved once to all of their addresses.
+func ResolveAddressSet(entries []string) (AddressSet, error) {
+	set := make(AddressSet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		prefix, err := parseAddressEntry(entry)
+		if err == nil {
+			set = append(set, prefix)
+			continue
+		}
+		if strings.ContainsAny(entry, "/:") {
+			return nil, err
+		}
+		ips, err := net.LookupIP(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %q: %w", entry, err)
+		}
+		for _, ip := range ips {
+			if addr, ok := netip.AddrFromSlice(ip); ok {
+				addr = addr.Unmap()
+				set = append(set, netip.PrefixFrom(addr, addr.BitLen()))
+			}
+		}
+	}
+	return set, nil
+}
+
+func parseAddressEntry(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address %q: %w", entry, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Contains reports whether host is in the set. Zones are ignored, since prefixes never match a zoned
+// address, and IPv4-mapped IPv6 addresses match as IPv4.
+func (s AddressSet) Contains(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.WithZone("").Unmap()
+	for _, prefix := range s {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessList decides which client addresses may connect. Deny entries take precedence, and when
+// allow entries exist a client must match one of them.
+type AccessList struct {
+	allow AddressSet
+	deny  AddressSet
+}
+
+func NewAccessList(allow, deny []string) (*AccessList, error) {
+	allowSet, err := ResolveAddressSet(allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow list: %w", err)
+	}
+	denySet, err := ResolveAddressSet(deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny list: %w", err)
+	}
+	return &AccessList{allow: allowSet, deny: denySet}, nil
+}
+
+func (a *AccessList) Permits(host string) bool {
+	if a.deny.Contains(host) {
+		return false
+	}
+	return len(a.allow) == 0 || a.allow.Contains(host)
+}
@@ -0,0 +1,59 @@
+package clipd
+
+import "testing"
+
+func TestAccessListPermits(t *testing.T) {
+	access, err := NewAccessList([]string{"192.168.1.0/24", "fe80::/10"}, []string{"192.168.1.13", "fe80::1"})
+	if err != nil {
+		t.Fatalf("NewAccessList: %v", err)
+	}
+	open, err := NewAccessList(nil, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("NewAccessList: %v", err)
+	}
+	tests := []struct {
+		name   string
+		access *AccessList
+		host   string
+		want   bool
+	}{
+		{"allowed", access, "192.168.1.20", true},
+		{"not allowed", access, "192.168.2.20", false},
+		{"deny wins over allow", access, "192.168.1.13", false},
+		{"IPv4-mapped allowed", access, "::ffff:192.168.1.20", true},
+		{"IPv4-mapped denied", access, "::ffff:192.168.1.13", false},
+		{"zoned allowed", access, "fe80::2%eth0", true},
+		{"zoned denied", access, "fe80::1%eth0", false},
+		{"unparseable", access, "not-an-address", false},
+		{"no allow list", open, "192.0.2.1", true},
+		{"no allow list denied", open, "10.1.2.3", false},
+		{"no allow list mapped denied", open, "::ffff:10.1.2.3", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.access.Permits(tt.host); got != tt.want {
+				t.Fatalf("Permits(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAddressSet(t *testing.T) {
+	set, err := ParseAddressSet([]string{" ::ffff:192.0.2.1 ", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("ParseAddressSet: %v", err)
+	}
+	for _, host := range []string{"192.0.2.1", "2001:db8::1", "2001:db8::1%3"} {
+		if !set.Contains(host) {
+			t.Errorf("Contains(%q) = false, want true", host)
+		}
+	}
+	if set.Contains("192.0.2.2") {
+		t.Errorf("Contains(192.0.2.2) = true, want false")
+	}
+	for _, entry := range []string{"192.0.2.1/33", "example"} {
+		if _, err := ParseAddressSet([]string{entry}); err == nil {
+			t.Errorf("ParseAddressSet(%q) succeeded", entry)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package clipd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ApproverDialog   = "dialog"
+	ApproverTerminal = "terminal"
+
+	defaultApprovalTimeout = time.Minute
+)
+
+// ApprovalRequest describes a program waiting for someone on the server to confirm it may run.
+type ApprovalRequest struct {
+	Program    string
+	Args       []string
+	WorkingDir string
+	Verb       string
+	Client     string
+	Credential string
+	Rule       string
+}
+
+func (r ApprovalRequest) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Program: %s\n", r.Program)
+	if len(r.Args) > 0 {
+		fmt.Fprintf(&sb, "Arguments: %s\n", strings.Join(r.Args, " "))
+	}
+	if r.WorkingDir != "" {
+		fmt.Fprintf(&sb, "Working directory: %s\n", r.WorkingDir)
+	}
+	if r.Verb != "" {
+		fmt.Fprintf(&sb, "Verb: %s\n", r.Verb)
+	}
+	fmt.Fprintf(&sb, "Client: %s (%s)\n", r.Client, r.Credential)
+	fmt.Fprintf(&sb, "Policy rule: %s", r.Rule)
+	return sb.String()
+}
+
+// Approver asks a person whether a request may run. Approve returns when they answer or ctx is done;
+// implementations should give up promptly once ctx expires.
+type Approver interface {
+	Approve(ctx context.Context, req ApprovalRequest) (bool, error)
+}
+
+// ApproverFunc adapts a function to the Approver interface.
+type ApproverFunc func(ctx context.Context, req ApprovalRequest) (bool, error)
+
+func (f ApproverFunc) Approve(ctx context.Context, req ApprovalRequest) (bool, error) {
+	return f(ctx, req)
+}
+
+// TerminalApprover prompts on Out and reads a yes or no answer from In. Prompts are shown one at a time,
+// and input typed before a prompt, such as a late answer to one that timed out, is ignored.
+type TerminalApprover struct {
+	In  io.Reader
+	Out io.Writer
+
+	mu    sync.Mutex
+	once  sync.Once
+	lines chan string
+}
+
+func (t *TerminalApprover) Approve(ctx context.Context, req ApprovalRequest) (bool, error) {
+	t.once.Do(func() {
+		t.lines = make(chan string)
+		go func() {
+			scanner := bufio.NewScanner(t.In)
+			for scanner.Scan() {
+				t.lines <- scanner.Text()
+			}
+			close(t.lines)
+		}()
+	})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.discardStale(); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(t.Out, "\nA client wants to run a program:\n%s\nAllow? [y/N] ", req)
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(t.Out, "\nTimed out waiting for an answer.")
+		return false, ctx.Err()
+	case line, ok := <-t.lines:
+		if !ok {
+			return false, fmt.Errorf("terminal input closed")
+		}
+		line = strings.ToLower(strings.TrimSpace(line))
+		return line == "y" || line == "yes", nil
+	}
+}
+
+// discardStale drops lines read since the last prompt was answered. t.mu must be held.
+func (t *TerminalApprover) discardStale() error {
+	for {
+		select {
+		case _, ok := <-t.lines:
+			if !ok {
+				return fmt.Errorf("terminal input closed")
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+const (
+	ApprovalDenied   = "denied"
+	ApprovalTimedOut = "timed out"
+)
+
+// ApprovalError is returned for requests that were not approved.
+type ApprovalError struct {
+	Outcome string
+	Program string
+}
+
+func (e *ApprovalError) Error() string {
+	return fmt.Sprintf("running %s was %s", e.Program, e.Outcome)
+}
+
+// RequestApproval asks approver about req and returns nil if it was approved within timeout, or an
+// *ApprovalError saying whether it was denied or timed out.
+func RequestApproval(approver Approver, timeout time.Duration, req ApprovalRequest) error {
+	if timeout <= 0 {
+		timeout = defaultApprovalTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	approved, err := approver.Approve(ctx, req)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || (err == nil && !approved && ctx.Err() != nil):
+		return &ApprovalError{Outcome: ApprovalTimedOut, Program: req.Program}
+	case err != nil:
+		return fmt.Errorf("approval failed: %w", err)
+	case !approved:
+		return &ApprovalError{Outcome: ApprovalDenied, Program: req.Program}
+	}
+	return nil
+}
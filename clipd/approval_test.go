@@ -0,0 +1,110 @@
+package clipd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRequestApproval(t *testing.T) {
+	failure := errors.New("no display")
+	tests := []struct {
+		name     string
+		approver ApproverFunc
+		outcome  string
+		err      error
+	}{
+		{
+			name:     "approved",
+			approver: func(ctx context.Context, req ApprovalRequest) (bool, error) { return true, nil },
+		},
+		{
+			name:     "denied",
+			approver: func(ctx context.Context, req ApprovalRequest) (bool, error) { return false, nil },
+			outcome:  ApprovalDenied,
+		},
+		{
+			name: "timed out",
+			approver: func(ctx context.Context, req ApprovalRequest) (bool, error) {
+				<-ctx.Done()
+				return false, ctx.Err()
+			},
+			outcome: ApprovalTimedOut,
+		},
+		{
+			name: "no answer before the timeout",
+			approver: func(ctx context.Context, req ApprovalRequest) (bool, error) {
+				<-ctx.Done()
+				return false, nil
+			},
+			outcome: ApprovalTimedOut,
+		},
+		{
+			name:     "approver failed",
+			approver: func(ctx context.Context, req ApprovalRequest) (bool, error) { return false, failure },
+			err:      failure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RequestApproval(tt.approver, 10*time.Millisecond, ApprovalRequest{Program: `C:\x.exe`})
+			var approvalErr *ApprovalError
+			switch {
+			case tt.outcome != "":
+				if !errors.As(err, &approvalErr) || approvalErr.Outcome != tt.outcome || approvalErr.Program != `C:\x.exe` {
+					t.Fatalf("RequestApproval: err = %v, want %s", err, tt.outcome)
+				}
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) || errors.As(err, &approvalErr) {
+					t.Fatalf("RequestApproval: err = %v, want %v", err, tt.err)
+				}
+			case err != nil:
+				t.Fatalf("RequestApproval: %v", err)
+			}
+		})
+	}
+}
+
+// promptWriter reports each approval prompt written to it.
+type promptWriter chan struct{}
+
+func (w promptWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("Allow?")) {
+		w <- struct{}{}
+	}
+	return len(p), nil
+}
+
+func TestTerminalApproverIgnoresLateAnswers(t *testing.T) {
+	in, typed := io.Pipe()
+	defer typed.Close()
+	prompted := make(promptWriter, 2)
+	approver := &TerminalApprover{In: in, Out: prompted}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := approver.Approve(ctx, ApprovalRequest{Program: "first.exe"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unanswered Approve: err = %v, want a timeout", err)
+	}
+	<-prompted
+	// The operator answers the first prompt after it timed out.
+	io.WriteString(typed, "y\n")
+	time.Sleep(10 * time.Millisecond)
+
+	answered := make(chan bool, 1)
+	go func() {
+		approved, err := approver.Approve(context.Background(), ApprovalRequest{Program: "second.exe"})
+		if err != nil {
+			t.Errorf("Approve: %v", err)
+		}
+		answered <- approved
+	}()
+	<-prompted
+	io.WriteString(typed, "n\n")
+	if <-answered {
+		t.Fatalf("a late answer to an earlier prompt approved the next request")
+	}
+}
@@ -0,0 +1,158 @@
+package clipd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	AuditResultOK           = "ok"
+	AuditResultError        = "error"
+	AuditResultUnauthorized = "unauthorized"
+	AuditResultMalformed    = "malformed"
+	AuditResultRateLimited  = "rate_limited"
+	AuditResultLockedOut    = "locked_out"
+	AuditResultPaused       = "paused"
+	AuditResultForbidden    = "forbidden"
+	AuditResultDenied       = "denied"
+	AuditResultTimedOut     = "timed_out"
+)
+
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Client      string    `json:"client"`
+	Credential  string    `json:"credential,omitempty"`
+	Type        string    `json:"type"`
+	Program     string    `json:"program,omitempty"`
+	Verb        string    `json:"verb,omitempty"`
+	Args        []string  `json:"args,omitempty"`
+	WorkingDir  string    `json:"workingDir,omitempty"`
+	ContentHash string    `json:"contentHash,omitempty"`
+	ContentSize int       `json:"contentSize,omitempty"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"durationMs"`
+}
+
+type AuditFilter struct {
+	Since  time.Time `json:"since,omitzero"`
+	Until  time.Time `json:"until,omitzero"`
+	Types  []string  `json:"types,omitempty"`
+	Client string    `json:"client,omitempty"`
+	Limit  int       `json:"limit,omitempty"`
+}
+
+func (f *AuditFilter) Match(entry AuditEntry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	if f.Client != "" && f.Client != entry.Client {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == entry.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditLog is an append-only JSON lines file of handled requests.
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log at %s: %w", path, err)
+	}
+	return &AuditLog{path: path, file: file}, nil
+}
+
+// Reopen switches the log to path, closing the previous file.
+func (l *AuditLog) Reopen(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log at %s: %w", path, err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.file.Close()
+	l.path = path
+	l.file = file
+	return nil
+}
+
+func (l *AuditLog) Append(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// Query returns the entries matching filter, oldest first. When filter.Limit is set only the most recent matches are kept.
+func (l *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !filter.Match(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+		if filter.Limit > 0 && len(entries) > filter.Limit {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// HashContent returns the hex encoded SHA-256 of s so content can be correlated without being stored.
+func HashContent(s string) string {
+	if s == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,70 @@
+package clipd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditFilterMatch(t *testing.T) {
+	base := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	entry := AuditEntry{Time: base, Client: "10.0.0.7", Type: "run"}
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   bool
+	}{
+		{name: "empty", filter: AuditFilter{}, want: true},
+		{name: "since before", filter: AuditFilter{Since: base.Add(-time.Hour)}, want: true},
+		{name: "since after", filter: AuditFilter{Since: base.Add(time.Hour)}, want: false},
+		{name: "until after", filter: AuditFilter{Until: base.Add(time.Hour)}, want: true},
+		{name: "until before", filter: AuditFilter{Until: base.Add(-time.Hour)}, want: false},
+		{name: "client", filter: AuditFilter{Client: "10.0.0.7"}, want: true},
+		{name: "other client", filter: AuditFilter{Client: "10.0.0.8"}, want: false},
+		{name: "type listed", filter: AuditFilter{Types: []string{"pipe", "run"}}, want: true},
+		{name: "type not listed", filter: AuditFilter{Types: []string{"clipboard"}}, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(entry); got != tt.want {
+			t.Errorf("%s: Match = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAuditLogQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	base := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	for i, typ := range []string{"run", "clipboard", "run", "pipe", "run"} {
+		if err := log.Append(AuditEntry{Time: base.Add(time.Duration(i) * time.Minute), Client: "10.0.0.7", Type: typ, Result: AuditResultOK}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Lines that do not decode, as after a crash or a hand edit, are skipped.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("{\"time\": \"not a time\"\n")
+	file.Close()
+
+	entries, err := log.Query(AuditFilter{Types: []string{"run"}})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Query returned %d run entries, want 3", len(entries))
+	}
+	entries, err = log.Query(AuditFilter{Types: []string{"run"}, Limit: 2})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(entries) != 2 || !entries[0].Time.Equal(base.Add(2*time.Minute)) || !entries[1].Time.Equal(base.Add(4*time.Minute)) {
+		t.Fatalf("Query with limit 2 = %+v, want the two most recent runs, oldest first", entries)
+	}
+}
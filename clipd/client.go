@@ -1,60 +1,431 @@
 package clipd
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
+	"time"
 )
 
-func SendClipboardRequest(address, data, password string) error {
+func SendClipboardRequest(address, data string, auth Auth) error {
 	request := Request{
-		Type:     RequestTypeClipboard,
-		Data:     data,
-		Password: password,
+		Type: RequestTypeClipboard,
+		Data: data,
 	}
-	return sendRequest(address, request)
+	_, err := sendRequest(address, request, auth)
+	return err
 }
 
-func SendRunRequest(address, program string, args []string, workingDir, password string) error {
+// RunOptions holds the optional settings of requests that start programs.
+type RunOptions struct {
+	// ApprovalTimeout limits how long to wait when the server's policy requires approval.
+	ApprovalTimeout time.Duration
+	// Env and CleanEnv set the program's environment; see Request.
+	Env      map[string]string
+	CleanEnv bool
+	// Timeout kills the program and everything it started if it runs longer.
+	Timeout time.Duration
+	// Verb is the shell verb of run requests; see Request.
+	Verb string
+}
+
+func (o RunOptions) apply(request *Request) {
+	request.ApprovalTimeout = Duration(o.ApprovalTimeout)
+	request.Timeout = Duration(o.Timeout)
+	request.Env = o.Env
+	request.CleanEnv = o.CleanEnv
+	request.Verb = o.Verb
+}
+
+func SendRunRequest(address, program string, args []string, workingDir string, options RunOptions, auth Auth) error {
 	request := Request{
 		Type:       RequestTypeRun,
 		Data:       program,
 		Args:       args,
 		WorkingDir: workingDir,
-		Password:   password,
 	}
-	return sendRequest(address, request)
+	options.apply(&request)
+	_, err := sendRequest(address, request, auth)
+	return err
 }
 
-func SendPipeRequest(address, program string, args []string, workingDir, stdin, password string) error {
+// SendRunWaitRequest runs program on the server and returns how it exited once it has.
+func SendRunWaitRequest(address, program string, args []string, workingDir string, options RunOptions, auth Auth) (ExitStatus, error) {
 	request := Request{
-		Type:       RequestTypePipe,
+		Type:       RequestTypeRun,
 		Data:       program,
 		Args:       args,
 		WorkingDir: workingDir,
-		Password:   password,
-		Stdin:      stdin,
+		Wait:       true,
 	}
-	return sendRequest(address, request)
+	options.apply(&request)
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return ExitStatus{}, err
+	}
+	var info ProcessInfo
+	if err := json.Unmarshal(response.Data, &info); err != nil {
+		return ExitStatus{}, fmt.Errorf("error decoding process: %w", err)
+	}
+	return info.Status(), nil
 }
 
-func sendRequest(address string, request Request) error {
-	jsonData, err := json.Marshal(request)
+// SendEditRequest opens a copy of the file called name holding content in program on the server,
+// passing the file to save each time the editor saves it. It returns once the editor exits.
+func SendEditRequest(address, program string, args []string, name string, content []byte, save func([]byte) error, options RunOptions, auth Auth) (ExitStatus, error) {
+	request := Request{
+		Type:     RequestTypeEdit,
+		Data:     program,
+		Args:     args,
+		FileName: name,
+		File:     content,
+	}
+	options.apply(&request)
+	conn, stream, err := openStream(address, request, auth)
 	if err != nil {
-		return fmt.Errorf("error marshalling data: %w", err)
+		return ExitStatus{}, err
 	}
-	conn, err := net.Dial("tcp", address)
+	defer conn.Close()
+	return receiveEdits(stream, save)
+}
+
+// SendDetachedRunRequest starts program on the server as a job and returns it without waiting for
+// the program to exit.
+func SendDetachedRunRequest(address, program string, args []string, workingDir string, options RunOptions, auth Auth) (*JobInfo, error) {
+	request := Request{
+		Type:       RequestTypeRun,
+		Data:       program,
+		Args:       args,
+		WorkingDir: workingDir,
+		Detach:     true,
+	}
+	options.apply(&request)
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	var info JobInfo
+	if err := json.Unmarshal(response.Data, &info); err != nil {
+		return nil, fmt.Errorf("error decoding job: %w", err)
+	}
+	return &info, nil
+}
+
+// SendPipeRequest starts program on the server and streams stdin to it as it is read, returning once
+// the program has been given all of it or stops reading. A signal received from signals before then
+// stops the program, and SendPipeRequest returns an error saying how it ended.
+func SendPipeRequest(address, program string, args []string, workingDir string, stdin io.Reader, signals <-chan string, options RunOptions, auth Auth) error {
+	request := Request{
+		Type:        RequestTypePipe,
+		Data:        program,
+		Args:        args,
+		WorkingDir:  workingDir,
+		StreamStdin: true,
+	}
+	options.apply(&request)
+	conn, stream, err := openStream(address, request, auth)
 	if err != nil {
-		return fmt.Errorf("failed to connect to server: %w", err)
+		return err
 	}
 	defer conn.Close()
+	frame, err := runSession(stream, SessionIO{Stdin: stdin, Signals: signals}, FrameEOF)
+	if err != nil {
+		return err
+	}
+	if frame.Type == FrameExit && frame.Reason != "" {
+		return fmt.Errorf("program %s", ExitStatus{Code: frame.Code, Reason: frame.Reason})
+	}
+	return nil
+}
+
+// SendExecRequest runs program on the server, streaming input to it and copying its output as it
+// arrives, and returns how it ended.
+func SendExecRequest(address, program string, args []string, workingDir string, options RunOptions, auth Auth, sio SessionIO) (ExitStatus, error) {
+	request := Request{
+		Type:       RequestTypeExec,
+		Data:       program,
+		Args:       args,
+		WorkingDir: workingDir,
+	}
+	return sendSessionRequest(address, request, options, auth, sio)
+}
+
+// SendShellRequest runs program interactively in a pseudo console of the given size on the server.
+// Its output, including stderr, is written to sio.Stdout.
+func SendShellRequest(address, program string, args []string, workingDir string, size TerminalSize, options RunOptions, auth Auth, sio SessionIO) (ExitStatus, error) {
+	request := Request{
+		Type:       RequestTypeShell,
+		Data:       program,
+		Args:       args,
+		WorkingDir: workingDir,
+		Terminal:   &size,
+	}
+	return sendSessionRequest(address, request, options, auth, sio)
+}
+
+func sendSessionRequest(address string, request Request, options RunOptions, auth Auth, sio SessionIO) (ExitStatus, error) {
+	options.apply(&request)
+	conn, stream, err := openStream(address, request, auth)
+	if err != nil {
+		return ExitStatus{}, err
+	}
+	defer conn.Close()
+	frame, err := runSession(stream, sio, FrameExit)
+	if err != nil {
+		return ExitStatus{}, err
+	}
+	return ExitStatus{Code: frame.Code, Reason: frame.Reason}, nil
+}
+
+func SendAuditRequest(address string, filter AuditFilter, auth Auth) ([]AuditEntry, error) {
+	request := Request{
+		Type:        RequestTypeAudit,
+		AuditFilter: &filter,
+	}
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	if err := json.Unmarshal(response.Data, &entries); err != nil {
+		return nil, fmt.Errorf("error decoding audit entries: %w", err)
+	}
+	return entries, nil
+}
+
+func SendStatsRequest(address string, auth Auth) (*MetricsSnapshot, error) {
+	request := Request{
+		Type: RequestTypeStats,
+	}
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot MetricsSnapshot
+	if err := json.Unmarshal(response.Data, &snapshot); err != nil {
+		return nil, fmt.Errorf("error decoding stats: %w", err)
+	}
+	return &snapshot, nil
+}
+
+func SendAdminRequest(address, verb string, args []string, auth Auth) (json.RawMessage, error) {
+	request := Request{
+		Type: RequestTypeAdmin,
+		Data: verb,
+		Args: args,
+	}
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+func SendProcessListRequest(address string, auth Auth) ([]ProcessInfo, error) {
+	request := Request{
+		Type: RequestTypeProcess,
+		Data: ProcessVerbList,
+	}
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	var list []ProcessInfo
+	if err := json.Unmarshal(response.Data, &list); err != nil {
+		return nil, fmt.Errorf("error decoding processes: %w", err)
+	}
+	return list, nil
+}
+
+// SendProcessRequest applies verb, kill or wait, to the process with id and returns its state
+// afterwards. A wait request is answered once the process exits.
+func SendProcessRequest(address, verb, id string, auth Auth) (*ProcessInfo, error) {
+	request := Request{
+		Type: RequestTypeProcess,
+		Data: verb,
+		Args: []string{id},
+	}
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	var info ProcessInfo
+	if err := json.Unmarshal(response.Data, &info); err != nil {
+		return nil, fmt.Errorf("error decoding process: %w", err)
+	}
+	return &info, nil
+}
+
+func SendJobListRequest(address string, auth Auth) ([]JobInfo, error) {
+	request := Request{
+		Type: RequestTypeJob,
+		Data: JobVerbList,
+	}
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	var list []JobInfo
+	if err := json.Unmarshal(response.Data, &list); err != nil {
+		return nil, fmt.Errorf("error decoding jobs: %w", err)
+	}
+	return list, nil
+}
+
+// SendJobStatusRequest returns the state of the job with id. With wait the server answers once the
+// job has finished.
+func SendJobStatusRequest(address, id string, wait bool, auth Auth) (*JobInfo, error) {
+	verb := JobVerbStatus
+	if wait {
+		verb = JobVerbWait
+	}
+	request := Request{
+		Type: RequestTypeJob,
+		Data: verb,
+		Args: []string{id},
+	}
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	var info JobInfo
+	if err := json.Unmarshal(response.Data, &info); err != nil {
+		return nil, fmt.Errorf("error decoding job: %w", err)
+	}
+	return &info, nil
+}
+
+func SendJobLogsRequest(address, id string, auth Auth) (*JobLogs, error) {
+	request := Request{
+		Type: RequestTypeJob,
+		Data: JobVerbLogs,
+		Args: []string{id},
+	}
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	var logs JobLogs
+	if err := json.Unmarshal(response.Data, &logs); err != nil {
+		return nil, fmt.Errorf("error decoding job logs: %w", err)
+	}
+	return &logs, nil
+}
+
+func SendPolicyTestRequest(address, program string, args []string, workingDir, verb string, auth Auth) (*PolicyDecision, error) {
+	request := Request{
+		Type:       RequestTypePolicyTest,
+		Data:       program,
+		Args:       args,
+		WorkingDir: workingDir,
+		Verb:       verb,
+	}
+	response, err := sendRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	var decision PolicyDecision
+	if err := json.Unmarshal(response.Data, &decision); err != nil {
+		return nil, fmt.Errorf("error decoding policy decision: %w", err)
+	}
+	return &decision, nil
+}
+
+// Auth holds the client's credentials. When Key is set the client signs the server's challenge
+// with it and Password is not sent, otherwise it authenticates with Password.
+type Auth struct {
+	Password string
+	Key      ed25519.PrivateKey
+}
+
+func sendRequest(address string, request Request, auth Auth) (*Response, error) {
+	conn, _, response, err := openRequest(address, request, auth)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	return response, nil
+}
+
+// openStream sends a streaming request and returns the open connection and a stream for its frames
+// once the server has accepted it.
+func openStream(address string, request Request, auth Auth) (net.Conn, *Stream, error) {
+	conn, decoder, _, err := openRequest(address, request, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, NewStream(conn, decoder), nil
+}
+
+// openRequest sends request and reads the server's response, leaving the connection open on success.
+func openRequest(address string, request Request, auth Auth) (net.Conn, *json.Decoder, *Response, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	response, decoder, err := exchange(conn, request, auth)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	return conn, decoder, response, nil
+}
+
+func exchange(conn net.Conn, request Request, auth Auth) (*Response, *json.Decoder, error) {
+	if auth.Key != nil {
+		request.PublicKey = auth.Key.Public().(ed25519.PublicKey)
+	} else {
+		request.Password = auth.Password
+	}
+	if err := writeMessage(conn, request); err != nil {
+		return nil, nil, err
+	}
+	decoder := json.NewDecoder(conn)
+	if auth.Key != nil {
+		if err := answerChallenge(conn, decoder, auth.Key); err != nil {
+			return nil, nil, err
+		}
+	}
+	var response Response
+	if err := decoder.Decode(&response); err != nil {
+		if err == io.EOF {
+			return nil, nil, fmt.Errorf("server closed the connection without responding")
+		}
+		return nil, nil, fmt.Errorf("error reading response from server: %w", err)
+	}
+	if response.Status != StatusOK {
+		return nil, nil, &ResponseError{Status: response.Status, Message: response.Error}
+	}
+	return &response, decoder, nil
+}
+
+func writeMessage(conn net.Conn, message any) error {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error marshalling data: %w", err)
+	}
 	if _, err := conn.Write(jsonData); err != nil {
 		return fmt.Errorf("error writing to server: %w", err)
 	}
-	buf := make([]byte, 1024)
-	if _, err := conn.Read(buf); err != nil && err != io.EOF {
-		return fmt.Errorf("error reading response from server: %w", err)
-	}
 	return nil
 }
+
+// answerChallenge signs the challenge the server sends for key authentication. A server that rejects
+// the key outright sends its response instead, which is left for the caller to read.
+func answerChallenge(conn net.Conn, decoder *json.Decoder, key ed25519.PrivateKey) error {
+	var challenge Challenge
+	raw := json.RawMessage{}
+	if err := decoder.Decode(&raw); err != nil {
+		return fmt.Errorf("error reading challenge from server: %w", err)
+	}
+	if err := json.Unmarshal(raw, &challenge); err != nil || len(challenge.Challenge) == 0 {
+		var response Response
+		if err := json.Unmarshal(raw, &response); err == nil && response.Status != "" && response.Status != StatusOK {
+			return &ResponseError{Status: response.Status, Message: response.Error}
+		}
+		return fmt.Errorf("server does not support key authentication")
+	}
+	return writeMessage(conn, Proof{Signature: SignChallenge(key, challenge.Challenge)})
+}
@@ -0,0 +1,21 @@
+package clipd
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestExchangeWithoutResponse(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		// A server that gives up on the request closes the connection without answering.
+		var request Request
+		json.NewDecoder(server).Decode(&request)
+		server.Close()
+	}()
+	if _, _, err := exchange(client, Request{Type: RequestTypeRun, Data: "notepad.exe"}, Auth{Password: "secret"}); err == nil {
+		t.Fatalf("exchange reported success for a request the server never answered")
+	}
+}
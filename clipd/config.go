@@ -3,9 +3,20 @@ package clipd
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	configFileName       = ".clipd"
+	defaultShutdownGrace = 10 * time.Second
+	defaultKillGrace     = 5 * time.Second
+	defaultJobRetention  = 24 * time.Hour
+	defaultJobLogBytes   = 1 << 20
 )
 
 type Config struct {
@@ -13,6 +24,193 @@ type Config struct {
 	ServerPort    int               `json:"serverPort"`
 	DriveMappings map[string]string `json:"driveMappings,omitempty"`
 	Password      string            `json:"password,omitempty"`
+	AdminPassword string            `json:"adminPassword,omitempty"`
+	IdentityFile  string            `json:"identityFile,omitempty"`
+	ForwardEnv    []string          `json:"forwardEnv,omitempty"`
+	// Editor is the Windows program, with any arguments, that clipd edit opens files in.
+	Editor string       `json:"editor,omitempty"`
+	Server ServerConfig `json:"server,omitzero"`
+}
+
+type ServerConfig struct {
+	Listen          []ListenerConfig   `json:"listen,omitempty"`
+	AllowAnyAddress bool               `json:"allowAnyAddress,omitempty"`
+	Allow           []string           `json:"allow,omitempty"`
+	Deny            []string           `json:"deny,omitempty"`
+	PolicyFile      string             `json:"policyFile,omitempty"`
+	Credentials     []CredentialConfig `json:"credentials,omitempty"`
+	AuthorizedKeys  string             `json:"authorizedKeys,omitempty"`
+	AdminPassword   string             `json:"adminPassword,omitempty"`
+	AuditLog        string             `json:"auditLog,omitempty"`
+	MetricsAddress  string             `json:"metricsAddress,omitempty"`
+	LogFile         string             `json:"logFile,omitempty"`
+	ShutdownGrace   Duration           `json:"shutdownGrace,omitempty"`
+	KillGrace       Duration           `json:"killGrace,omitempty"`
+	Limits          LimitsConfig       `json:"limits,omitzero"`
+	Lockout         LockoutConfig      `json:"lockout,omitzero"`
+	Hooks           []HookConfig       `json:"hooks,omitempty"`
+	Approval        ApprovalConfig     `json:"approval,omitzero"`
+	Sandbox         SandboxConfig      `json:"sandbox,omitzero"`
+	Jobs            JobsConfig         `json:"jobs,omitzero"`
+}
+
+// JobsConfig controls detached jobs. Retention is how long completed jobs are kept, and MaxLogBytes how
+// much of the most recent output of each of a job's stdout and stderr.
+type JobsConfig struct {
+	Retention   Duration `json:"retention,omitempty"`
+	MaxLogBytes int64    `json:"maxLogBytes,omitempty"`
+}
+
+// ApprovalConfig chooses how requests matching a requireApproval policy rule are confirmed. Approver is
+// "dialog" (the default) for a message box on the server's desktop or "terminal" for the server's console.
+type ApprovalConfig struct {
+	Approver string   `json:"approver,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty"`
+}
+
+// LockoutConfig controls how the server reacts to repeated authentication failures.
+type LockoutConfig struct {
+	MaxFailures int      `json:"maxFailures,omitempty"`
+	BaseDelay   Duration `json:"baseDelay,omitempty"`
+	BanDuration Duration `json:"banDuration,omitempty"`
+	MaxBan      Duration `json:"maxBan,omitempty"`
+	Allowlist   []string `json:"allowlist,omitempty"`
+}
+
+// ListenerConfig is one address the server accepts requests on. Password overrides the top-level
+// password for this listener, and Features restricts the request types it accepts.
+type ListenerConfig struct {
+	Address  string   `json:"address"`
+	Password string   `json:"password,omitempty"`
+	Features []string `json:"features,omitempty"`
+}
+
+// Allows reports whether requests of type t are enabled on the listener.
+func (l ListenerConfig) Allows(t RequestType) bool {
+	if len(l.Features) == 0 {
+		return true
+	}
+	for _, feature := range l.Features {
+		if feature == t.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (l ListenerConfig) validate(allowAny bool) error {
+	host, port, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", l.Address, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("listen address %q must have a port between 1 and 65535", l.Address)
+	}
+	ip := net.ParseIP(host)
+	if host != "" && ip == nil {
+		return fmt.Errorf("listen address %q must be an IPv4 or IPv6 address", l.Address)
+	}
+	if (host == "" || ip.IsUnspecified()) && !allowAny {
+		return fmt.Errorf("listening on all interfaces with %q requires allowAnyAddress", l.Address)
+	}
+	for _, feature := range l.Features {
+		if _, err := ParseRequestType(feature); err != nil {
+			return fmt.Errorf("listen address %q: %w", l.Address, err)
+		}
+	}
+	return nil
+}
+
+// validateLocalAddress checks that address is a host:port that only accepts connections from this
+// machine, for endpoints that have no authentication of their own.
+func validateLocalAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%q is not a host:port address: %w", address, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("%q must have a port between 1 and 65535", address)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("%q must be a loopback address such as 127.0.0.1:9464", address)
+	}
+	return nil
+}
+
+// ServerAddress is the address clients connect to.
+func (c *Config) ServerAddress() string {
+	return net.JoinHostPort(c.ServerIP, strconv.Itoa(c.ServerPort))
+}
+
+// Listeners returns the server's listen configuration. Without a server.listen section the server
+// listens on the client's serverIP and serverPort with the top-level password.
+func (c *Config) Listeners() []ListenerConfig {
+	if len(c.Server.Listen) > 0 {
+		return c.Server.Listen
+	}
+	return []ListenerConfig{{Address: c.ServerAddress()}}
+}
+
+// Listener returns the listener bound to address.
+func (c *Config) Listener(address string) (ListenerConfig, bool) {
+	for _, l := range c.Listeners() {
+		if l.Address == address {
+			return l, true
+		}
+	}
+	return ListenerConfig{}, false
+}
+
+// ListenerPassword returns the password required on l.
+func (c *Config) ListenerPassword(l ListenerConfig) string {
+	if l.Password != "" {
+		return l.Password
+	}
+	return c.Password
+}
+
+// LimitsConfig bounds how much work clients can create. Zero values mean unlimited.
+type LimitsConfig struct {
+	MaxConnections             int      `json:"maxConnections,omitempty"`
+	MaxConnectionsPerClient    int      `json:"maxConnectionsPerClient,omitempty"`
+	MaxProcesses               int      `json:"maxProcesses,omitempty"`
+	MaxProcessesPerClient      int      `json:"maxProcessesPerClient,omitempty"`
+	RequestsPerSecond          float64  `json:"requestsPerSecond,omitempty"`
+	RequestsPerSecondPerClient float64  `json:"requestsPerSecondPerClient,omitempty"`
+	Burst                      int      `json:"burst,omitempty"`
+	QueueTimeout               Duration `json:"queueTimeout,omitempty"`
+	MaxRuntime                 Duration `json:"maxRuntime,omitempty"`
+	MaxOutputBytes             int64    `json:"maxOutputBytes,omitempty"`
+	MaxMemoryMB                int      `json:"maxMemoryMB,omitempty"`
+}
+
+// Duration is a time.Duration that is written in config files as a string such as "30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ConfigPath returns the location of the config file read by LoadConfig.
+func ConfigPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(homeDir, configFileName), nil
 }
 
 func LoadConfig() (*Config, error) {
@@ -20,7 +218,7 @@ func LoadConfig() (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user home directory: %w", err)
 	}
-	configPath := filepath.Join(homeDir, ".clipd")
+	configPath := filepath.Join(homeDir, configFileName)
 	configFile, err := os.Open(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open config file at %s: %w", configPath, err)
@@ -32,15 +230,120 @@ func LoadConfig() (*Config, error) {
 	}
 	config.ServerIP = os.ExpandEnv(config.ServerIP)
 	config.Password = os.ExpandEnv(config.Password)
+	config.AdminPassword = os.ExpandEnv(config.AdminPassword)
+	config.Server.AdminPassword = os.ExpandEnv(config.Server.AdminPassword)
 	for key, value := range config.DriveMappings {
 		config.DriveMappings[key] = os.ExpandEnv(value)
 	}
-	if config.ServerIP == "" {
+	config.Server.AuditLog = expandHomePath(os.ExpandEnv(config.Server.AuditLog))
+	if config.Server.AuditLog == "" {
+		config.Server.AuditLog = filepath.Join(homeDir, ".clipd-audit.jsonl")
+	}
+	config.Server.PolicyFile = expandHomePath(os.ExpandEnv(config.Server.PolicyFile))
+	config.IdentityFile = expandHomePath(os.ExpandEnv(config.IdentityFile))
+	config.Server.AuthorizedKeys = expandHomePath(os.ExpandEnv(config.Server.AuthorizedKeys))
+	if config.Server.AuthorizedKeys == "" {
+		config.Server.AuthorizedKeys = filepath.Join(homeDir, ".clipd_authorized_keys")
+	}
+	config.Server.LogFile = expandHomePath(os.ExpandEnv(config.Server.LogFile))
+	if config.Server.LogFile == "" {
+		config.Server.LogFile = filepath.Join(homeDir, ".clipd-server.log")
+	}
+	if config.ServerIP == "" && len(config.Server.Listen) == 0 {
 		return nil, fmt.Errorf("serverIP is required in config")
 	}
-	if config.ServerPort <= 0 || config.ServerPort > 65535 {
+	if config.ServerIP != "" && (config.ServerPort <= 0 || config.ServerPort > 65535) {
 		return nil, fmt.Errorf("serverPort must be between 1 and 65535")
 	}
+	seen := make(map[string]bool)
+	for i := range config.Server.Listen {
+		listener := &config.Server.Listen[i]
+		listener.Password = os.ExpandEnv(listener.Password)
+		if err := listener.validate(config.Server.AllowAnyAddress); err != nil {
+			return nil, err
+		}
+		if seen[listener.Address] {
+			return nil, fmt.Errorf("listen address %q is configured more than once", listener.Address)
+		}
+		seen[listener.Address] = true
+	}
+	if config.Server.MetricsAddress != "" {
+		if err := validateLocalAddress(config.Server.MetricsAddress); err != nil {
+			return nil, fmt.Errorf("invalid metricsAddress: %w", err)
+		}
+	}
+	if config.Server.ShutdownGrace <= 0 {
+		config.Server.ShutdownGrace = Duration(defaultShutdownGrace)
+	}
+	if config.Server.KillGrace <= 0 {
+		config.Server.KillGrace = Duration(defaultKillGrace)
+	}
+	if err := config.Server.Limits.validate(); err != nil {
+		return nil, err
+	}
+	if config.Server.Jobs.Retention < 0 || config.Server.Jobs.MaxLogBytes < 0 {
+		return nil, fmt.Errorf("jobs settings must not be negative")
+	}
+	if config.Server.Jobs.Retention == 0 {
+		config.Server.Jobs.Retention = Duration(defaultJobRetention)
+	}
+	if config.Server.Jobs.MaxLogBytes == 0 {
+		config.Server.Jobs.MaxLogBytes = defaultJobLogBytes
+	}
+	if _, err := ParseAddressSet(config.Server.Lockout.Allowlist); err != nil {
+		return nil, fmt.Errorf("invalid lockout allowlist: %w", err)
+	}
+	names := make(map[string]bool)
+	passwords := make(map[string]bool)
+	// Named credentials are checked first, so one sharing the default password would shadow it.
+	defaultPasswords := make(map[string]bool)
+	if config.Password != "" {
+		defaultPasswords[config.Password] = true
+	}
+	for _, listener := range config.Server.Listen {
+		if listener.Password != "" {
+			defaultPasswords[listener.Password] = true
+		}
+	}
+	for i := range config.Server.Credentials {
+		cred := &config.Server.Credentials[i]
+		cred.Password = os.ExpandEnv(cred.Password)
+		if err := cred.compile(); err != nil {
+			return nil, err
+		}
+		if names[cred.Name] || cred.Name == DefaultCredentialName || cred.Name == AdminCredentialName {
+			return nil, fmt.Errorf("credential name %q is reserved or used more than once", cred.Name)
+		}
+		if passwords[cred.Password] {
+			return nil, fmt.Errorf("credential %q shares its password with another credential", cred.Name)
+		}
+		if defaultPasswords[cred.Password] {
+			return nil, fmt.Errorf("credential %q shares its password with the top-level or a listener password", cred.Name)
+		}
+		names[cred.Name] = true
+		passwords[cred.Password] = true
+	}
+	for i, hook := range config.Server.Hooks {
+		if err := hook.validate(); err != nil {
+			return nil, fmt.Errorf("invalid hook %d: %w", i+1, err)
+		}
+	}
+	for i, root := range config.Server.Sandbox.Roots {
+		config.Server.Sandbox.Roots[i] = os.ExpandEnv(root)
+	}
+	if err := config.Server.Sandbox.compile(); err != nil {
+		return nil, err
+	}
+	switch config.Server.Approval.Approver {
+	case "":
+		config.Server.Approval.Approver = ApproverDialog
+	case ApproverDialog, ApproverTerminal:
+	default:
+		return nil, fmt.Errorf("approval approver must be %q or %q", ApproverDialog, ApproverTerminal)
+	}
+	if config.Server.Approval.Timeout <= 0 {
+		config.Server.Approval.Timeout = Duration(defaultApprovalTimeout)
+	}
 	return &config, nil
 }
 
@@ -80,6 +383,39 @@ func ResolvePath(path string, mappings map[string]string) string {
 	return path
 }
 
+// ResolveTarget returns the Windows form of something to open: URLs and Windows paths are returned as
+// they are, and local paths are made absolute and resolved through mappings.
+func ResolveTarget(target string, mappings map[string]string) (string, error) {
+	if IsURL(target) || hasDriveLetter(target) || strings.HasPrefix(target, `\\`) {
+		return target, nil
+	}
+	path, err := filepath.Abs(expandHomePath(target))
+	if err != nil {
+		return "", err
+	}
+	resolved := ResolvePath(path, mappings)
+	if resolved == path {
+		return "", fmt.Errorf("%s is not under a drive mapping", target)
+	}
+	return resolved, nil
+}
+
+// IsURL reports whether target starts with a URL scheme, like https: or mailto:. Single letters are
+// taken for drive letters rather than schemes.
+func IsURL(target string) bool {
+	scheme, _, found := strings.Cut(target, ":")
+	if !found || len(scheme) < 2 {
+		return false
+	}
+	for i, c := range scheme {
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !letter && (i == 0 || !(c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.')) {
+			return false
+		}
+	}
+	return true
+}
+
 func expandHomePath(path string) string {
 	if !strings.HasPrefix(path, "~") {
 		return path
@@ -105,6 +441,52 @@ func ResolveArgs(args []string, mappings map[string]string) []string {
 	return resolved
 }
 
+// SplitCommand splits an editor setting into a program and its arguments. Words are separated by
+// spaces, and double or single quotes group words containing spaces, as in
+// "C:\Program Files\Notepad++\notepad++.exe" -multiInst. Backslashes are kept as they are, since
+// they separate Windows paths. A setting without quotes that is a Windows path to an .exe, like
+// C:\Program Files\Notepad++\notepad++.exe, is taken as the program alone.
+func SplitCommand(command string) ([]string, error) {
+	command = strings.TrimSpace(command)
+	if !strings.ContainsAny(command, `"'`) && (hasDriveLetter(command) || strings.HasPrefix(command, `\\`)) && strings.HasSuffix(strings.ToLower(command), ".exe") {
+		return []string{command}, nil
+	}
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range command {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, command)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return words, nil
+}
+
 func GetWorkingDir(mappings map[string]string) (string, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -112,3 +494,16 @@ func GetWorkingDir(mappings map[string]string) (string, error) {
 	}
 	return ResolvePath(cwd, mappings), nil
 }
+
+func (l LimitsConfig) validate() error {
+	if l.MaxConnections < 0 || l.MaxConnectionsPerClient < 0 || l.MaxProcesses < 0 || l.MaxProcessesPerClient < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if l.MaxRuntime < 0 || l.MaxOutputBytes < 0 || l.MaxMemoryMB < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if l.RequestsPerSecond < 0 || l.RequestsPerSecondPerClient < 0 || l.Burst < 0 || l.QueueTimeout < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package clipd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loadTestConfig writes content as the config file in a temporary home directory and loads it.
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	if err := os.WriteFile(filepath.Join(home, configFileName), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig()
+}
+
+func TestLoadConfigMetricsAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{address: "127.0.0.1:9464"},
+		{address: "[::1]:9464"},
+		{address: "localhost:9464"},
+		{address: ":9100", wantErr: true},
+		{address: "0.0.0.0:9100", wantErr: true},
+		{address: "192.168.1.10:9100", wantErr: true},
+		{address: "metrics.example.com:9100", wantErr: true},
+		{address: "127.0.0.1", wantErr: true},
+		{address: "127.0.0.1:0", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, `{"serverIP": "127.0.0.1", "serverPort": 7000, "server": {"metricsAddress": "`+tt.address+`"}}`)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "metricsAddress") {
+				t.Errorf("metricsAddress %q: err = %v, want a metricsAddress error", tt.address, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("metricsAddress %q: %v", tt.address, err)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{command: "notepad.exe", want: []string{"notepad.exe"}},
+		{command: "  code.exe   --wait ", want: []string{"code.exe", "--wait"}},
+		{command: `"C:\Program Files\Notepad++\notepad++.exe" -multiInst -nosession`, want: []string{`C:\Program Files\Notepad++\notepad++.exe`, "-multiInst", "-nosession"}},
+		{command: `'C:\Program Files\Vim\vim91\gvim.exe' -f`, want: []string{`C:\Program Files\Vim\vim91\gvim.exe`, "-f"}},
+		{command: `C:\Program Files\Notepad++\notepad++.exe`, want: []string{`C:\Program Files\Notepad++\notepad++.exe`}},
+		{command: `\\tools\bin\My Editor.EXE`, want: []string{`\\tools\bin\My Editor.EXE`}},
+		{command: `C:\Tools\vim.exe -u NONE`, want: []string{`C:\Tools\vim.exe`, "-u", "NONE"}},
+		{command: `editor.exe --title="my notes" ""`, want: []string{"editor.exe", "--title=my notes", ""}},
+		{command: `"C:\Program Files\x.exe`, wantErr: true},
+		{command: "   ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := SplitCommand(tt.command)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("SplitCommand(%q) = %q, want error", tt.command, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("SplitCommand(%q) = %q, %v, want %q", tt.command, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package clipd
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultCredentialName identifies requests authenticated with the top-level or listener password.
+const DefaultCredentialName = "default"
+
+// AdminCredentialName identifies admin requests authenticated with the server's admin password.
+const AdminCredentialName = "admin"
+
+// CredentialConfig is a named password with its own permissions. Allow lists the request types it
+// may make and Programs the executables it may run, as globs like policy rules. Empty lists allow everything.
+type CredentialConfig struct {
+	Name      string    `json:"name"`
+	Password  string    `json:"password"`
+	Allow     []string  `json:"allow,omitempty"`
+	Programs  []string  `json:"programs,omitempty"`
+	RateLimit RateLimit `json:"rateLimit,omitzero"`
+
+	programs []*regexp.Regexp
+}
+
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+	Burst             int     `json:"burst,omitempty"`
+}
+
+func (c *CredentialConfig) compile() error {
+	if c.Name == "" {
+		return fmt.Errorf("credential needs a name")
+	}
+	if c.Password == "" {
+		return fmt.Errorf("credential %q needs a password", c.Name)
+	}
+	for _, t := range c.Allow {
+		if _, err := ParseRequestType(t); err != nil {
+			return fmt.Errorf("credential %q: %w", c.Name, err)
+		}
+	}
+	if c.RateLimit.RequestsPerSecond < 0 || c.RateLimit.Burst < 0 {
+		return fmt.Errorf("credential %q: rate limit must not be negative", c.Name)
+	}
+	c.programs = c.programs[:0]
+	for _, pattern := range c.Programs {
+		c.programs = append(c.programs, globRegexp(pattern))
+	}
+	return nil
+}
+
+// Allows reports whether the credential may make requests of type t.
+func (c *CredentialConfig) Allows(t RequestType) bool {
+	if len(c.Allow) == 0 {
+		return t != RequestTypeAdmin || c.Name == DefaultCredentialName
+	}
+	for _, allowed := range c.Allow {
+		if allowed == t.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the credential explicitly grants admin requests.
+func (c *CredentialConfig) IsAdmin() bool {
+	for _, allowed := range c.Allow {
+		if allowed == RequestTypeAdmin.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsProgram reports whether the credential may run program, given as its resolved path.
+func (c *CredentialConfig) AllowsProgram(program string) bool {
+	if len(c.programs) == 0 {
+		return true
+	}
+	for i, re := range c.programs {
+		target := program
+		if !strings.ContainsAny(c.Programs[i], `\/`) {
+			target = windowsBase(program)
+		}
+		if re.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate returns the credential matching password on listener. The listener or top-level
+// password authenticates as the default credential, which has full access; it is disabled when named
+// credentials exist and no such password is set.
+func (c *Config) Authenticate(password string, listener ListenerConfig) (*CredentialConfig, bool) {
+	var match *CredentialConfig
+	for i := range c.Server.Credentials {
+		cred := &c.Server.Credentials[i]
+		if subtle.ConstantTimeCompare([]byte(cred.Password), []byte(password)) == 1 {
+			match = cred
+		}
+	}
+	if match != nil {
+		return match, true
+	}
+	defaultPassword := c.ListenerPassword(listener)
+	if defaultPassword == "" && len(c.Server.Credentials) > 0 {
+		return nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(defaultPassword), []byte(password)) == 1 {
+		return &CredentialConfig{Name: DefaultCredentialName}, true
+	}
+	return nil, false
+}
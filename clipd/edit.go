@@ -0,0 +1,78 @@
+package clipd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// editPollInterval is how often ServeEdit checks the edited file for saves.
+const editPollInterval = 500 * time.Millisecond
+
+// ServeEdit sends the client the content of the file at path each time the editor saves it; original
+// is the content the client sent. Once the editor's exit status arrives on exited it sends any last
+// save and then FrameExit. It returns early if the client goes away.
+func ServeEdit(stream *Stream, path string, original []byte, exited <-chan ExitStatus) error {
+	gone := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := stream.Receive(); err != nil {
+				gone <- err
+				return
+			}
+		}
+	}()
+	last := original
+	// sendSave sends the file if it changed. While the editor runs, errors are retried on the next
+	// poll: editors may hold the file locked or leave it missing for a moment while saving.
+	sendSave := func(final bool) error {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			if !final {
+				return nil
+			}
+			return fmt.Errorf("failed to read edited file: %w", err)
+		}
+		if bytes.Equal(content, last) {
+			return nil
+		}
+		last = content
+		return stream.Send(Frame{Type: FrameFile, Data: content})
+	}
+	ticker := time.NewTicker(editPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sendSave(false); err != nil {
+				return err
+			}
+		case status := <-exited:
+			if err := sendSave(true); err != nil {
+				return err
+			}
+			return stream.Send(Frame{Type: FrameExit, Code: status.Code, Reason: status.Reason})
+		case err := <-gone:
+			return fmt.Errorf("client went away: %w", err)
+		}
+	}
+}
+
+// receiveEdits passes each version of the file the server sends to save until the editor exits.
+func receiveEdits(stream *Stream, save func([]byte) error) (ExitStatus, error) {
+	for {
+		frame, err := stream.Receive()
+		if err != nil {
+			return ExitStatus{}, fmt.Errorf("connection lost before the editor exited: %w", err)
+		}
+		switch frame.Type {
+		case FrameFile:
+			if err := save(frame.Data); err != nil {
+				return ExitStatus{}, err
+			}
+		case FrameExit:
+			return ExitStatus{Code: frame.Code, Reason: frame.Reason}, nil
+		}
+	}
+}
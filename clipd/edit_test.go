@@ -0,0 +1,48 @@
+package clipd
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeEditSendsSavesAndExit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "COMMIT_EDITMSG")
+	if err := os.WriteFile(path, []byte("draft"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	exited := make(chan ExitStatus, 1)
+	served := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		served <- ServeEdit(NewStream(serverConn, json.NewDecoder(serverConn)), path, []byte("draft"), exited)
+	}()
+
+	// The editor saves and exits before the next poll, so the save has to be picked up on exit.
+	if err := os.WriteFile(path, []byte("final message"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	exited <- ExitStatus{Code: 0}
+
+	var saves []string
+	status, err := receiveEdits(NewStream(clientConn, json.NewDecoder(clientConn)), func(content []byte) error {
+		saves = append(saves, string(content))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("receiveEdits: %v", err)
+	}
+	if status != (ExitStatus{}) {
+		t.Fatalf("status = %v, want exit code 0", status)
+	}
+	if len(saves) != 1 || saves[0] != "final message" {
+		t.Fatalf("saves = %q, want the final content once", saves)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("ServeEdit: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package clipd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ParseEnvAssignment parses a KEY=VALUE argument. A bare KEY takes its value from the local
+// environment and is skipped if it is not set there.
+func ParseEnvAssignment(assignment string, env map[string]string) error {
+	name, value, found := strings.Cut(assignment, "=")
+	if !found {
+		if value, ok := os.LookupEnv(name); ok {
+			env[name] = value
+		}
+		return nil
+	}
+	if name == "" {
+		return fmt.Errorf("invalid environment variable %q: missing name", assignment)
+	}
+	env[name] = value
+	return nil
+}
+
+// ReadEnvFile adds the variables in a file of KEY=VALUE lines to env. Blank lines and lines starting
+// with # are ignored, and values may be wrapped in single or double quotes.
+func ReadEnvFile(path string, env map[string]string) error {
+	file, err := os.Open(expandHomePath(path))
+	if err != nil {
+		return fmt.Errorf("failed to open env file: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNumber)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read env file: %w", err)
+	}
+	return nil
+}
+
+// ForwardedEnv returns the local values of the named variables that are set.
+func ForwardedEnv(names []string) map[string]string {
+	env := make(map[string]string)
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// ValidateEnv checks that env can be passed to a Windows program.
+func ValidateEnv(env map[string]string) error {
+	for name, value := range env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("environment variable %s contains a NUL character", name)
+		}
+	}
+	return nil
+}
+
+// MergeEnvironment returns the environment for a program given env by a client. It starts from base,
+// or when clean from only the variables of base that keep reports, and sets env over it. Names are
+// compared without regard to case, as on Windows. The result is sorted, as Windows expects.
+func MergeEnvironment(base []string, env map[string]string, clean bool, keep func(name string) bool) []string {
+	merged := make(map[string]string)
+	for _, entry := range base {
+		// Windows keeps per-drive working directories in variables named like =C:, so a leading = is
+		// part of the name.
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry[1:], "=")
+		if i < 0 {
+			continue
+		}
+		name := entry[:i+1]
+		if clean && !keep(name) {
+			continue
+		}
+		merged[strings.ToUpper(name)] = entry
+	}
+	for name, value := range env {
+		merged[strings.ToUpper(name)] = name + "=" + value
+	}
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]string, len(keys))
+	for i, key := range keys {
+		result[i] = merged[key]
+	}
+	return result
+}
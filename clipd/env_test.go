@@ -0,0 +1,169 @@
+package clipd
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvAssignment(t *testing.T) {
+	t.Setenv("CLIPD_TEST_LOCAL", "from here")
+	os.Unsetenv("CLIPD_TEST_UNSET")
+	tests := []struct {
+		assignment string
+		want       map[string]string
+		wantErr    bool
+	}{
+		{assignment: "GOFLAGS=-mod=vendor", want: map[string]string{"GOFLAGS": "-mod=vendor"}},
+		{assignment: "EMPTY=", want: map[string]string{"EMPTY": ""}},
+		{assignment: "CLIPD_TEST_LOCAL", want: map[string]string{"CLIPD_TEST_LOCAL": "from here"}},
+		{assignment: "CLIPD_TEST_UNSET", want: map[string]string{}},
+		{assignment: "=value", wantErr: true},
+	}
+	for _, tt := range tests {
+		env := make(map[string]string)
+		err := ParseEnvAssignment(tt.assignment, env)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseEnvAssignment(%q) succeeded", tt.assignment)
+			}
+			continue
+		}
+		if err != nil || !maps.Equal(env, tt.want) {
+			t.Errorf("ParseEnvAssignment(%q) = %v, %v, want %v", tt.assignment, env, err, tt.want)
+		}
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "comments, blank lines and quotes",
+			content: "# settings\n\nA=1\n  export B = two words \nC=\"quoted # not a comment\"\nD='single'\nE=\nF=\"unbalanced\n" +
+				"G=a=b\n",
+			want: map[string]string{"A": "1", "B": "two words", "C": "quoted # not a comment", "D": "single", "E": "", "F": `"unbalanced`, "G": "a=b"},
+		},
+		{name: "later lines win", content: "A=1\nA=2\n", want: map[string]string{"A": "2"}},
+		{name: "missing =", content: "A=1\nJUSTANAME\n", wantErr: true},
+		{name: "missing name", content: "=1\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "env")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			env := make(map[string]string)
+			err := ReadEnvFile(path, env)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), path+":") {
+					t.Fatalf("ReadEnvFile: err = %v, want an error naming the line", err)
+				}
+				return
+			}
+			if err != nil || !maps.Equal(env, tt.want) {
+				t.Fatalf("ReadEnvFile = %v, %v, want %v", env, err, tt.want)
+			}
+		})
+	}
+	if err := ReadEnvFile(filepath.Join(t.TempDir(), "missing"), map[string]string{}); err == nil {
+		t.Fatalf("ReadEnvFile of a missing file succeeded")
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	tests := []struct {
+		env     map[string]string
+		wantErr bool
+	}{
+		{env: map[string]string{"PATH": `C:\bin`, "EMPTY": ""}},
+		{env: map[string]string{"": "x"}, wantErr: true},
+		{env: map[string]string{"=C:": `C:\work`}, wantErr: true},
+		{env: map[string]string{"A=B": "x"}, wantErr: true},
+		{env: map[string]string{"A\x00": "x"}, wantErr: true},
+		{env: map[string]string{"A": "x\x00y"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := ValidateEnv(tt.env); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateEnv(%q) = %v, want error %v", tt.env, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMergeEnvironment(t *testing.T) {
+	base := []string{`Path=C:\Windows`, `=C:=C:\work`, "SystemRoot=C:\\Windows", "TEMP=C:\\Temp", "", "junk"}
+	tests := []struct {
+		name  string
+		env   map[string]string
+		clean bool
+		want  []string
+	}{
+		{
+			name: "inherit and override ignoring case",
+			env:  map[string]string{"PATH": `D:\bin`, "NEW": ""},
+			want: []string{`=C:=C:\work`, "NEW=", `PATH=D:\bin`, `SystemRoot=C:\Windows`, `TEMP=C:\Temp`},
+		},
+		{
+			name:  "clean keeps only kept variables",
+			env:   map[string]string{"NEW": "1"},
+			clean: true,
+			want:  []string{"NEW=1", `SystemRoot=C:\Windows`},
+		},
+	}
+	keep := func(name string) bool { return strings.EqualFold(name, "SystemRoot") }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeEnvironment(base, tt.env, tt.clean, keep); !slices.Equal(got, tt.want) {
+				t.Fatalf("MergeEnvironment = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyProtectedEnv(t *testing.T) {
+	policy := &Policy{ProtectedEnv: []string{"PATH", "CLIPD_*"}}
+	if err := policy.compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{env: map[string]string{"GOFLAGS": "-v"}},
+		{env: map[string]string{"Path": `D:\evil`}, want: "Path"},
+		{env: map[string]string{"clipd_token": "x", "A": "1"}, want: "clipd_token"},
+		{env: map[string]string{"PATHEXT": ".EXE"}},
+	}
+	for _, tt := range tests {
+		err := policy.CheckEnv(tt.env)
+		var envErr *EnvPolicyError
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("CheckEnv(%q) = %v, want nil", tt.env, err)
+			}
+			continue
+		}
+		if !errors.As(err, &envErr) || envErr.Name != tt.want {
+			t.Errorf("CheckEnv(%q) = %v, want an EnvPolicyError for %s", tt.env, err, tt.want)
+		}
+	}
+	var nilPolicy *Policy
+	if err := nilPolicy.CheckEnv(map[string]string{"PATH": "x"}); err != nil {
+		t.Errorf("a nil policy protected PATH: %v", err)
+	}
+
+	// A clean environment keeps the server's value of a protected variable.
+	got := MergeEnvironment([]string{`PATH=C:\Windows`, "HOME=C:\\Users\\me"}, map[string]string{"A": "1"}, true, policy.ProtectsEnv)
+	if want := []string{"A=1", `PATH=C:\Windows`}; !slices.Equal(got, want) {
+		t.Errorf("clean MergeEnvironment = %q, want %q", got, want)
+	}
+}
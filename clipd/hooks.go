@@ -0,0 +1,171 @@
+package clipd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	EventClipboardReceived = "clipboard_received"
+	EventProcessLaunched   = "process_launched"
+	EventProcessExited     = "process_exited"
+	EventAuthFailed        = "auth_failed"
+	EventJobCompleted      = "job_completed"
+)
+
+var hookEvents = []string{EventClipboardReceived, EventProcessLaunched, EventProcessExited, EventAuthFailed, EventJobCompleted}
+
+const (
+	defaultHookTimeout = 10 * time.Second
+	maxRunningHooks    = 8
+)
+
+// HookConfig runs a command or posts to a URL when one of Events happens. The event is passed
+// as JSON on the command's stdin or as the request body.
+type HookConfig struct {
+	Events  []string `json:"events"`
+	Command []string `json:"command,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+func (h HookConfig) validate() error {
+	if len(h.Events) == 0 {
+		return fmt.Errorf("hook must list at least one event")
+	}
+	for _, event := range h.Events {
+		known := false
+		for _, e := range hookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return fmt.Errorf("unknown hook event %q", event)
+		}
+	}
+	if (len(h.Command) == 0) == (h.URL == "") {
+		return fmt.Errorf("hook must have exactly one of command or url")
+	}
+	return nil
+}
+
+type Event struct {
+	Name       string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client,omitempty"`
+	Credential string    `json:"credential,omitempty"`
+	Program    string    `json:"program,omitempty"`
+	Args       []string  `json:"args,omitempty"`
+	WorkingDir string    `json:"workingDir,omitempty"`
+	PID        int       `json:"pid,omitempty"`
+	// Job is the ID of the detached job a job_completed event is about.
+	Job      int  `json:"job,omitempty"`
+	ExitCode *int `json:"exitCode,omitempty"`
+	// Reason says why a process was stopped early, as in ExitStatus.
+	Reason      string `json:"reason,omitempty"`
+	Content     string `json:"content,omitempty"`
+	ContentSize int    `json:"contentSize,omitempty"`
+}
+
+// Hooks dispatches events to the configured hooks in the background. Emit never blocks:
+// events are dropped when too many hooks are already running.
+type Hooks struct {
+	// Logf reports hook failures and dropped events.
+	Logf    func(format string, args ...any)
+	mu      sync.Mutex
+	hooks   []HookConfig
+	running chan struct{}
+}
+
+func NewHooks(hooks []HookConfig) *Hooks {
+	return &Hooks{hooks: hooks, running: make(chan struct{}, maxRunningHooks)}
+}
+
+func (h *Hooks) SetHooks(hooks []HookConfig) {
+	h.mu.Lock()
+	h.hooks = hooks
+	h.mu.Unlock()
+}
+
+func (h *Hooks) Emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	h.mu.Lock()
+	hooks := h.hooks
+	h.mu.Unlock()
+	for _, hook := range hooks {
+		if !hook.handles(event.Name) {
+			continue
+		}
+		select {
+		case h.running <- struct{}{}:
+		default:
+			h.logf("Dropped %s hook: too many hooks running", event.Name)
+			continue
+		}
+		go func() {
+			defer func() { <-h.running }()
+			if err := hook.run(event); err != nil {
+				h.logf("Hook for %s failed: %v", event.Name, err)
+			}
+		}()
+	}
+}
+
+func (h *Hooks) logf(format string, args ...any) {
+	if h.Logf != nil {
+		h.Logf(format, args...)
+	}
+}
+
+func (h HookConfig) handles(name string) bool {
+	for _, event := range h.Events {
+		if event == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (h HookConfig) run(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	timeout := time.Duration(h.Timeout)
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if h.URL != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s responded with %s", h.URL, resp.Status)
+		}
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), "CLIPD_EVENT="+event.Name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", h.Command[0], err, bytes.TrimSpace(output))
+	}
+	return nil
+}
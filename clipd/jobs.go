@@ -0,0 +1,211 @@
+package clipd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobInfo is the state of a detached job. Its ID is also its ID in the server's process table.
+type JobInfo struct {
+	ProcessInfo
+	// OutputTruncated reports that the start of the job's output was dropped to keep its logs within
+	// the server's maxLogBytes.
+	OutputTruncated bool `json:"outputTruncated,omitempty"`
+}
+
+// JobLogs is the output a detached job has written so far.
+type JobLogs struct {
+	Stdout    []byte `json:"stdout,omitempty"`
+	Stderr    []byte `json:"stderr,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// JobStore keeps the output and exit status of detached jobs once the clients that started them have
+// gone. Completed jobs are forgotten when they are older than the configured retention.
+type JobStore struct {
+	mu     sync.Mutex
+	config JobsConfig
+	jobs   map[int]*Job
+}
+
+// Job is a detached job in a JobStore. Its program's output is copied to Stdout and Stderr.
+type Job struct {
+	store  *JobStore
+	info   JobInfo
+	stdout *tailBuffer
+	stderr *tailBuffer
+	done   chan struct{}
+}
+
+func NewJobStore(config JobsConfig) *JobStore {
+	return &JobStore{config: config, jobs: make(map[int]*Job)}
+}
+
+// SetConfig applies new settings. Jobs already running keep their log size.
+func (s *JobStore) SetConfig(config JobsConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+}
+
+// Add records a started job described by info, whose ID must be unique.
+func (s *JobStore) Add(info ProcessInfo) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	info.State = ProcessRunning
+	job := &Job{
+		store:  s,
+		info:   JobInfo{ProcessInfo: info},
+		stdout: &tailBuffer{max: int(s.config.MaxLogBytes)},
+		stderr: &tailBuffer{max: int(s.config.MaxLogBytes)},
+		done:   make(chan struct{}),
+	}
+	s.jobs[info.ID] = job
+	return job
+}
+
+func (j *Job) Stdout() io.Writer {
+	return j.stdout
+}
+
+func (j *Job) Stderr() io.Writer {
+	return j.stderr
+}
+
+// Finish records that the job's program exited with status and returns the job's final state. Its
+// output should be complete by then.
+func (j *Job) Finish(status ExitStatus) JobInfo {
+	j.store.mu.Lock()
+	defer j.store.mu.Unlock()
+	j.info.State = ProcessExited
+	j.info.ExitCode = &status.Code
+	j.info.Reason = status.Reason
+	j.info.Ended = time.Now()
+	close(j.done)
+	return j.snapshot()
+}
+
+// snapshot returns the job's state. The store's lock must be held.
+func (j *Job) snapshot() JobInfo {
+	info := j.info
+	info.OutputTruncated = j.stdout.truncated() || j.stderr.truncated()
+	return info
+}
+
+// List returns the jobs visible to credential, ordered by ID. An empty credential sees them all.
+func (s *JobStore) List(credential string) []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	list := []JobInfo{}
+	for _, job := range s.jobs {
+		if credential == "" || job.info.Credential == credential {
+			list = append(list, job.snapshot())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (s *JobStore) Status(id int, credential string) (JobInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, err := s.lookup(id, credential)
+	if err != nil {
+		return JobInfo{}, err
+	}
+	return job.snapshot(), nil
+}
+
+func (s *JobStore) Logs(id int, credential string) (JobLogs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, err := s.lookup(id, credential)
+	if err != nil {
+		return JobLogs{}, err
+	}
+	return JobLogs{
+		Stdout:    job.stdout.bytes(),
+		Stderr:    job.stderr.bytes(),
+		Truncated: job.stdout.truncated() || job.stderr.truncated(),
+	}, nil
+}
+
+// Wait blocks until the job with id has finished or cancel is closed, and returns its final state.
+func (s *JobStore) Wait(id int, credential string, cancel <-chan struct{}) (JobInfo, error) {
+	s.mu.Lock()
+	job, err := s.lookup(id, credential)
+	s.mu.Unlock()
+	if err != nil {
+		return JobInfo{}, err
+	}
+	select {
+	case <-job.done:
+	case <-cancel:
+		return JobInfo{}, fmt.Errorf("stopped waiting for job %d", id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return job.snapshot(), nil
+}
+
+// lookup finds a job, expiring old ones first. The store's lock must be held.
+func (s *JobStore) lookup(id int, credential string) (*Job, error) {
+	s.expire()
+	job, ok := s.jobs[id]
+	if !ok || (credential != "" && job.info.Credential != credential) {
+		return nil, fmt.Errorf("no such job: %d", id)
+	}
+	return job, nil
+}
+
+// expire forgets jobs that finished longer ago than the retention. The store's lock must be held.
+func (s *JobStore) expire() {
+	cutoff := time.Now().Add(-time.Duration(s.config.Retention))
+	for id, job := range s.jobs {
+		if job.info.State == ProcessExited && job.info.Ended.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu      sync.Mutex
+	max     int
+	data    []byte
+	dropped bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	if len(p) >= b.max {
+		b.dropped = b.dropped || len(b.data) > 0 || len(p) > b.max
+		b.data = append(b.data[:0], p[len(p)-b.max:]...)
+		return n, nil
+	}
+	if excess := len(b.data) + len(p) - b.max; excess > 0 {
+		b.data = b.data[:copy(b.data, b.data[excess:])]
+		b.dropped = true
+	}
+	b.data = append(b.data, p...)
+	return n, nil
+}
+
+func (b *tailBuffer) bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.data...)
+}
+
+func (b *tailBuffer) truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
@@ -0,0 +1,60 @@
+package clipd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 5}
+	b.Write([]byte("abc"))
+	if got := b.bytes(); string(got) != "abc" || b.truncated() {
+		t.Fatalf("after abc: %q, truncated %v", got, b.truncated())
+	}
+	b.Write([]byte("def"))
+	if got := b.bytes(); string(got) != "bcdef" || !b.truncated() {
+		t.Fatalf("after def: %q, truncated %v", got, b.truncated())
+	}
+	b.Write([]byte("0123456789"))
+	if got := b.bytes(); string(got) != "56789" {
+		t.Fatalf("after a long write: %q", got)
+	}
+}
+
+func TestJobStore(t *testing.T) {
+	store := NewJobStore(JobsConfig{Retention: Duration(time.Hour), MaxLogBytes: 1024})
+	job := store.Add(ProcessInfo{ID: 3, Program: "build.exe", Credential: "builds"})
+	job.Stdout().Write([]byte("compiling\n"))
+	job.Stderr().Write([]byte("warning\n"))
+	if _, err := store.Status(3, "default"); err == nil {
+		t.Fatalf("another credential could see the job")
+	}
+	done := make(chan JobInfo)
+	go func() {
+		info, err := store.Wait(3, "builds", nil)
+		if err != nil {
+			t.Errorf("Wait: %v", err)
+		}
+		done <- info
+	}()
+	job.Finish(ExitStatus{Code: 2})
+	if info := <-done; info.State != ProcessExited || *info.ExitCode != 2 {
+		t.Fatalf("Wait = %+v, want exited with code 2", info)
+	}
+	logs, err := store.Logs(3, "builds")
+	if err != nil {
+		t.Fatalf("Logs: %v", err)
+	}
+	if !bytes.Equal(logs.Stdout, []byte("compiling\n")) || !bytes.Equal(logs.Stderr, []byte("warning\n")) || logs.Truncated {
+		t.Fatalf("Logs = %+v", logs)
+	}
+
+	store.SetConfig(JobsConfig{Retention: Duration(time.Nanosecond), MaxLogBytes: 1024})
+	time.Sleep(time.Millisecond)
+	running := store.Add(ProcessInfo{ID: 4})
+	if list := store.List(""); len(list) != 1 || list[0].ID != 4 {
+		t.Fatalf("List after expiry = %+v, want only the running job", list)
+	}
+	running.Finish(ExitStatus{})
+}
@@ -0,0 +1,234 @@
+package clipd
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	publicKeyType   = "clipd-ed25519"
+	challengeSize   = 32
+	signaturePrefix = "clipd-auth-v1\x00"
+)
+
+// NewChallenge returns random bytes for a client to sign.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+func SignChallenge(key ed25519.PrivateKey, challenge []byte) []byte {
+	return ed25519.Sign(key, append([]byte(signaturePrefix), challenge...))
+}
+
+func VerifyChallenge(key ed25519.PublicKey, challenge, signature []byte) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, append([]byte(signaturePrefix), challenge...), signature)
+}
+
+// Fingerprint returns a short, stable identifier for key.
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// GenerateKeyFiles writes a new Ed25519 private key to path and its public key, in authorized_keys
+// format, to path + ".pub".
+func GenerateKeyFiles(path, comment string) (ed25519.PublicKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create private key file: %w", err)
+	}
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, fmt.Errorf("failed to write private key: %w", err)
+	}
+	line := FormatPublicKey(public, comment) + "\n"
+	if err := os.WriteFile(path+".pub", []byte(line), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write public key: %w", err)
+	}
+	return public, nil
+}
+
+func FormatPublicKey(key ed25519.PublicKey, comment string) string {
+	line := publicKeyType + " " + base64.StdEncoding.EncodeToString(key)
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
+
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("identity file %s does not contain a private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file %s: %w", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("identity file %s is not an Ed25519 key", path)
+	}
+	return private, nil
+}
+
+// AuthorizedKey is one line of an authorized keys file. Types and From restrict what the key may do
+// and where it may connect from; empty values allow everything except admin requests.
+type AuthorizedKey struct {
+	Key     ed25519.PublicKey
+	Comment string
+	Types   []string
+	From    AddressSet
+}
+
+// Credential returns the permissions of the key as a credential named after its comment or fingerprint.
+func (k *AuthorizedKey) Credential() *CredentialConfig {
+	name := k.Comment
+	if name == "" {
+		name = Fingerprint(k.Key)
+	}
+	return &CredentialConfig{Name: "key:" + name, Allow: k.Types}
+}
+
+type AuthorizedKeys []AuthorizedKey
+
+// LoadAuthorizedKeys reads a file with one key per line in the form
+//
+//	[options] clipd-ed25519 <base64 key> [comment]
+//
+// where options is a comma separated list of types="clipboard,run" and from="10.0.0.0/8,::1".
+// A missing file yields an empty list.
+func LoadAuthorizedKeys(path string) (AuthorizedKeys, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open authorized keys: %w", err)
+	}
+	defer file.Close()
+	var keys AuthorizedKeys
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := parseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+	return keys, nil
+}
+
+func parseAuthorizedKey(line string) (AuthorizedKey, error) {
+	var key AuthorizedKey
+	var options string
+	if !strings.HasPrefix(line, publicKeyType+" ") {
+		options, line = splitOptions(line)
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != publicKeyType {
+		return key, fmt.Errorf("expected %s followed by a key", publicKeyType)
+	}
+	raw, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return key, fmt.Errorf("invalid public key")
+	}
+	key.Key = ed25519.PublicKey(raw)
+	key.Comment = strings.Join(fields[2:], " ")
+	for _, option := range splitOptionList(options) {
+		name, value, _ := strings.Cut(option, "=")
+		value = strings.Trim(value, `"`)
+		switch name {
+		case "types":
+			for _, t := range strings.Split(value, ",") {
+				if _, err := ParseRequestType(t); err != nil {
+					return key, err
+				}
+				key.Types = append(key.Types, t)
+			}
+		case "from":
+			key.From, err = ResolveAddressSet(strings.Split(value, ","))
+			if err != nil {
+				return key, err
+			}
+		default:
+			return key, fmt.Errorf("unknown option %q", name)
+		}
+	}
+	return key, nil
+}
+
+// splitOptions separates the leading options field, which may contain quoted spaces, from the rest of line.
+func splitOptions(line string) (string, string) {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			return line[:i], strings.TrimSpace(line[i:])
+		}
+	}
+	return line, ""
+}
+
+func splitOptionList(options string) []string {
+	var list []string
+	quoted := false
+	start := 0
+	for i, r := range options {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			list = append(list, options[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(options) {
+		list = append(list, options[start:])
+	}
+	return list
+}
+
+// Find returns the entry for key.
+func (keys AuthorizedKeys) Find(key []byte) (*AuthorizedKey, bool) {
+	for i := range keys {
+		if keys[i].Key.Equal(ed25519.PublicKey(key)) {
+			return &keys[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,155 @@
+package clipd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func testKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return private
+}
+
+func TestParseAuthorizedKey(t *testing.T) {
+	public := testKey(t).Public().(ed25519.PublicKey)
+	line := FormatPublicKey(public, "")
+	tests := []struct {
+		name    string
+		line    string
+		comment string
+		types   []string
+		from    []string
+		notFrom []string
+		wantErr bool
+	}{
+		{name: "plain", line: line},
+		{name: "comment with spaces", line: line + " me at laptop", comment: "me at laptop"},
+		{name: "types", line: `types="clipboard,run" ` + line + " ci", comment: "ci", types: []string{"clipboard", "run"}},
+		{
+			name: "types and from", line: `types=clipboard,from="10.0.0.0/8,::1" ` + line,
+			types: []string{"clipboard"}, from: []string{"10.1.2.3", "::1"}, notFrom: []string{"192.0.2.1"},
+		},
+		{name: "unknown type", line: `types="clipboard,teleport" ` + line, wantErr: true},
+		{name: "invalid from", line: `from="10.0.0.0/33" ` + line, wantErr: true},
+		{name: "unknown option", line: `command="x" ` + line, wantErr: true},
+		{name: "wrong key type", line: "ssh-ed25519 AAAA", wantErr: true},
+		{name: "bad base64", line: publicKeyType + " not!base64", wantErr: true},
+		{name: "short key", line: publicKeyType + " AAAA", wantErr: true},
+		{name: "missing key", line: publicKeyType, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseAuthorizedKey(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseAuthorizedKey(%q) succeeded", tt.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAuthorizedKey(%q): %v", tt.line, err)
+			}
+			if !key.Key.Equal(public) || key.Comment != tt.comment || !slices.Equal(key.Types, tt.types) {
+				t.Fatalf("parseAuthorizedKey(%q) = %+v, want comment %q and types %q", tt.line, key, tt.comment, tt.types)
+			}
+			for _, host := range tt.from {
+				if !key.From.Contains(host) {
+					t.Errorf("key may not be used from %s", host)
+				}
+			}
+			for _, host := range tt.notFrom {
+				if key.From.Contains(host) {
+					t.Errorf("key may be used from %s", host)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadAuthorizedKeys(t *testing.T) {
+	first := testKey(t).Public().(ed25519.PublicKey)
+	second := testKey(t).Public().(ed25519.PublicKey)
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	content := "# clients\n\n" + FormatPublicKey(first, "laptop") + "\n" + `types="run" ` + FormatPublicKey(second, "") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadAuthorizedKeys(path)
+	if err != nil {
+		t.Fatalf("LoadAuthorizedKeys: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("loaded %d keys, want 2", len(keys))
+	}
+	key, ok := keys.Find(second)
+	if !ok {
+		t.Fatalf("Find did not find the second key")
+	}
+	if credential := key.Credential(); credential.Name != "key:"+Fingerprint(second) || !slices.Equal(credential.Allow, []string{"run"}) {
+		t.Fatalf("Credential() = %+v, want the fingerprint name allowing run", credential)
+	}
+	if credential := keys[0].Credential(); credential.Name != "key:laptop" {
+		t.Fatalf("Credential().Name = %q, want key:laptop", credential.Name)
+	}
+	if _, ok := keys.Find(testKey(t).Public().(ed25519.PublicKey)); ok {
+		t.Fatalf("Find matched an unknown key")
+	}
+
+	if err := os.WriteFile(path, []byte(content+"garbage\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAuthorizedKeys(path); err == nil {
+		t.Fatalf("LoadAuthorizedKeys accepted an invalid line")
+	}
+	if keys, err := LoadAuthorizedKeys(filepath.Join(t.TempDir(), "missing")); err != nil || keys != nil {
+		t.Fatalf("LoadAuthorizedKeys of a missing file = %v, %v, want no keys", keys, err)
+	}
+}
+
+func TestKeyAuthDoesNotSendPassword(t *testing.T) {
+	key := testKey(t)
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := exchange(client, Request{Type: RequestTypeClipboard}, Auth{Password: "secret", Key: key})
+		done <- err
+	}()
+
+	decoder := json.NewDecoder(server)
+	var request Request
+	if err := decoder.Decode(&request); err != nil {
+		t.Fatalf("reading the request: %v", err)
+	}
+	if request.Password != "" {
+		t.Fatalf("request sent password %q along with a key", request.Password)
+	}
+	challenge := []byte("0123456789abcdef0123456789abcdef")
+	if err := json.NewEncoder(server).Encode(Challenge{Challenge: challenge}); err != nil {
+		t.Fatal(err)
+	}
+	var proof Proof
+	if err := decoder.Decode(&proof); err != nil {
+		t.Fatalf("reading the proof: %v", err)
+	}
+	if !VerifyChallenge(request.PublicKey, challenge, proof.Signature) {
+		t.Fatalf("proof does not verify against the sent public key")
+	}
+	if err := json.NewEncoder(server).Encode(Response{Status: StatusOK}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package clipd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LaunchSpec describes a program to start for an exec or shell request.
+type LaunchSpec struct {
+	Program    string
+	Args       []string
+	WorkingDir string
+	// Terminal runs the program in a pseudo console of this size. Its output, including stderr,
+	// then arrives on Stdout.
+	Terminal *TerminalSize
+	// Env is the program's environment. Nil inherits the server's.
+	Env    []string
+	Limits ProcessLimits
+}
+
+// ProcessLimits bound a program and everything it starts. Zero values are unlimited.
+type ProcessLimits struct {
+	MaxRuntime time.Duration
+	// MaxMemory is the memory each process may commit, in bytes.
+	MaxMemory uint64
+}
+
+// LaunchSpec returns what to start for an exec or shell request.
+func (r *Request) LaunchSpec() LaunchSpec {
+	spec := LaunchSpec{Program: r.Data, Args: r.Args, WorkingDir: r.WorkingDir}
+	if r.Type == RequestTypeShell {
+		spec.Terminal = &TerminalSize{Cols: 80, Rows: 25}
+		if r.Terminal != nil && r.Terminal.Cols > 0 && r.Terminal.Rows > 0 {
+			spec.Terminal = r.Terminal
+		}
+	}
+	return spec
+}
+
+// Launcher starts processes for streaming requests. The server implements it with Windows pipes and
+// pseudo consoles.
+type Launcher interface {
+	Launch(spec LaunchSpec) (Process, error)
+}
+
+// Shell verbs a run request can ask the Windows shell to apply to its file, as lpVerb of ShellExecuteEx.
+const (
+	VerbOpen       = "open"
+	VerbEdit       = "edit"
+	VerbPrint      = "print"
+	VerbExplore    = "explore"
+	VerbProperties = "properties"
+	// VerbRunAs starts a program elevated, after the user confirms the UAC prompt on the Windows machine.
+	VerbRunAs = "runas"
+)
+
+var shellVerbs = []string{VerbOpen, VerbEdit, VerbPrint, VerbExplore, VerbProperties, VerbRunAs}
+
+// ShellSpec describes a file, program or URL to open through the Windows shell for a run request.
+type ShellSpec struct {
+	File       string
+	Args       []string
+	WorkingDir string
+	// Verb is the action to apply to File. Empty uses its default action.
+	Verb string
+	// InvokeIDList makes the shell look the verb up in the file's context menu, which verbs that are
+	// not registered for the file type, like properties, need.
+	InvokeIDList bool
+}
+
+// ShellSpec returns what to open for a run request, or an error if it asks for an unknown verb.
+func (r *Request) ShellSpec() (ShellSpec, error) {
+	spec := ShellSpec{File: r.Data, Args: r.Args, WorkingDir: r.WorkingDir, Verb: strings.ToLower(r.Verb)}
+	switch spec.Verb {
+	case "", VerbOpen, VerbEdit, VerbPrint, VerbExplore, VerbRunAs:
+	case VerbProperties:
+		spec.InvokeIDList = true
+	default:
+		return ShellSpec{}, fmt.Errorf("unknown verb %q, expected one of %s", r.Verb, strings.Join(shellVerbs, ", "))
+	}
+	return spec, nil
+}
+
+// ShellLauncher opens files for run requests. The server implements it with ShellExecuteEx.
+type ShellLauncher interface {
+	// ShellExecute returns a handle to the started process, which the caller must close, or 0 if the
+	// shell started none, as when a document opens in a program that is already running.
+	ShellExecute(spec ShellSpec) (uintptr, error)
+}
+
+// Resizer is implemented by processes running in a pseudo console.
+type Resizer interface {
+	Resize(size TerminalSize) error
+}
+
+// Interrupter is implemented by processes that can be sent a console Ctrl-C.
+type Interrupter interface {
+	Interrupt() error
+}
+
+// LimitReporter is implemented by processes that are killed when they exceed a ProcessLimits.
+type LimitReporter interface {
+	// ExceededLimit returns ExitTimedOut or ExitMemoryLimit if the process was killed for a limit.
+	ExceededLimit() string
+}
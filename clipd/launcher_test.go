@@ -0,0 +1,69 @@
+package clipd
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestShellSpecVerbs(t *testing.T) {
+	tests := []struct {
+		verb         string
+		want         string
+		invokeIDList bool
+		wantErr      bool
+	}{
+		{verb: "", want: ""},
+		{verb: "open", want: VerbOpen},
+		{verb: "Print", want: VerbPrint},
+		{verb: "runas", want: VerbRunAs},
+		{verb: "explore", want: VerbExplore},
+		{verb: "edit", want: VerbEdit},
+		{verb: "properties", want: VerbProperties, invokeIDList: true},
+		{verb: "delete", wantErr: true},
+	}
+	for _, tt := range tests {
+		r := &Request{Type: RequestTypeRun, Data: `C:\docs\report.pdf`, Args: []string{"/q"}, WorkingDir: `C:\docs`, Verb: tt.verb}
+		spec, err := r.ShellSpec()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("verb %q: err = %v, wantErr %v", tt.verb, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if spec.Verb != tt.want || spec.InvokeIDList != tt.invokeIDList {
+			t.Errorf("verb %q: got verb %q, InvokeIDList %v; want %q, %v", tt.verb, spec.Verb, spec.InvokeIDList, tt.want, tt.invokeIDList)
+		}
+		if spec.File != r.Data || spec.WorkingDir != r.WorkingDir || len(spec.Args) != 1 {
+			t.Errorf("verb %q: spec %+v does not match the request", tt.verb, spec)
+		}
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	home := t.TempDir()
+	mappings := map[string]string{"D:": home}
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{target: "https://example.com/a?b=c", want: "https://example.com/a?b=c"},
+		{target: "mailto:someone@example.com", want: "mailto:someone@example.com"},
+		{target: "ms-settings:display", want: "ms-settings:display"},
+		{target: `C:\Windows\notepad.exe`, want: `C:\Windows\notepad.exe`},
+		{target: `\\nas\share\file.txt`, want: `\\nas\share\file.txt`},
+		{target: filepath.Join(home, "docs", "a.txt"), want: `D:\docs\a.txt`},
+		{target: "/somewhere/else.txt", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveTarget(tt.target, mappings)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ResolveTarget(%q): err = %v, wantErr %v", tt.target, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ResolveTarget(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
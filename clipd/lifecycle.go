@@ -0,0 +1,169 @@
+package clipd
+
+import (
+	"net"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ActiveRequest struct {
+	ID      uint64    `json:"id"`
+	Client  string    `json:"client"`
+	Type    string    `json:"type,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+// Activity is the supervisor's record of one connection, updated by its handler as the request is understood.
+type Activity struct {
+	mu   sync.Mutex
+	info ActiveRequest
+	conn net.Conn
+}
+
+func (a *Activity) Describe(requestType, detail string) {
+	a.mu.Lock()
+	a.info.Type = requestType
+	a.info.Detail = detail
+	a.mu.Unlock()
+}
+
+func (a *Activity) ID() uint64 {
+	return a.info.ID
+}
+
+func (a *Activity) snapshot() ActiveRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.info
+}
+
+// Supervisor owns the server's listeners and connection handlers. It recovers handler panics
+// and drains in-flight requests on shutdown.
+type Supervisor struct {
+	// OnPanic is called with the connection, what is known of its request and the recovered value when a
+	// handler panics. The connection is closed once it returns.
+	OnPanic   func(conn net.Conn, request ActiveRequest, recovered any, stack []byte)
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+	nextID    uint64
+	closing   bool
+	listeners map[net.Listener]struct{}
+	active    map[uint64]*Activity
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		listeners: make(map[net.Listener]struct{}),
+		active:    make(map[uint64]*Activity),
+	}
+}
+
+// AddListener registers ln to be closed on shutdown. It reports false, closing ln, if shutdown has already begun.
+func (s *Supervisor) AddListener(ln net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		ln.Close()
+		return false
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+func (s *Supervisor) RemoveListener(ln net.Listener) {
+	s.mu.Lock()
+	delete(s.listeners, ln)
+	s.mu.Unlock()
+	ln.Close()
+}
+
+// Go runs handler for conn on a new goroutine, tracking it until it returns.
+func (s *Supervisor) Go(conn net.Conn, client string, handler func(net.Conn, *Activity)) {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.nextID++
+	activity := &Activity{
+		info: ActiveRequest{ID: s.nextID, Client: client, Started: time.Now()},
+		conn: conn,
+	}
+	s.active[activity.info.ID] = activity
+	s.wg.Add(1)
+	s.mu.Unlock()
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil && s.OnPanic != nil {
+				s.OnPanic(conn, activity.snapshot(), recovered, debug.Stack())
+			}
+			conn.Close()
+			s.mu.Lock()
+			delete(s.active, activity.info.ID)
+			s.mu.Unlock()
+			s.wg.Done()
+		}()
+		handler(conn, activity)
+	}()
+}
+
+func (s *Supervisor) Active() []ActiveRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := make([]ActiveRequest, 0, len(s.active))
+	for _, activity := range s.active {
+		active = append(active, activity.snapshot())
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].ID < active[j].ID })
+	return active
+}
+
+// Kick closes the connections whose ID or client address matches target, except the one with ID exclude.
+func (s *Supervisor) Kick(target string, exclude uint64) []ActiveRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kicked := []ActiveRequest{}
+	for id, activity := range s.active {
+		if id == exclude {
+			continue
+		}
+		if strconv.FormatUint(id, 10) == target || activity.info.Client == target {
+			kicked = append(kicked, activity.snapshot())
+			activity.conn.Close()
+		}
+	}
+	return kicked
+}
+
+// Shutdown closes all listeners, waits up to grace for active handlers to finish and then
+// closes the connections of those still running. It returns the requests that were interrupted.
+func (s *Supervisor) Shutdown(grace time.Duration) []ActiveRequest {
+	s.mu.Lock()
+	s.closing = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	s.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(grace):
+	}
+	interrupted := s.Active()
+	s.mu.Lock()
+	for _, activity := range s.active {
+		activity.conn.Close()
+	}
+	s.mu.Unlock()
+	return interrupted
+}
@@ -0,0 +1,44 @@
+package clipd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSupervisorAnswersPanics(t *testing.T) {
+	supervisor := NewSupervisor()
+	panicked := make(chan ActiveRequest, 1)
+	supervisor.OnPanic = func(conn net.Conn, request ActiveRequest, recovered any, stack []byte) {
+		panicked <- request
+		json.NewEncoder(conn).Encode(Response{Status: StatusError, Error: fmt.Sprintf("internal server error: %v", recovered)})
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	supervisor.Go(server, "192.0.2.1", func(conn net.Conn, activity *Activity) {
+		// Like the server's handler, leave closing the connection to the supervisor.
+		activity.Describe("run", "notepad.exe")
+		panic("boom")
+	})
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	var resp Response
+	if err := json.NewDecoder(client).Decode(&resp); err != nil {
+		t.Fatalf("reading the response to a panicking handler: %v", err)
+	}
+	if resp.Status != StatusError || resp.Error != "internal server error: boom" {
+		t.Fatalf("response = %+v, want an internal server error", resp)
+	}
+	if request := <-panicked; request.Client != "192.0.2.1" || request.Type != "run" || request.Detail != "notepad.exe" {
+		t.Fatalf("OnPanic got request %+v, want the run request from 192.0.2.1", request)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read after the response: err = %v, want the connection closed", err)
+	}
+	if interrupted := supervisor.Shutdown(time.Second); len(interrupted) != 0 {
+		t.Fatalf("Shutdown interrupted %+v, want the handler finished", interrupted)
+	}
+}
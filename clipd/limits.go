@@ -0,0 +1,200 @@
+package clipd
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+const idleBucketExpiry = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token, returning how long the caller must wait before it is usable.
+func (b *tokenBucket) reserve(now time.Time, rate float64, burst int) time.Duration {
+	capacity := math.Max(float64(burst), 1)
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+func newTokenBucket(now time.Time, burst int) *tokenBucket {
+	return &tokenBucket{tokens: math.Max(float64(burst), 1), last: now}
+}
+
+func (b *tokenBucket) cancel() {
+	b.tokens++
+}
+
+// Limiter enforces global and per-client bounds on connections, processes and request rate.
+type Limiter struct {
+	mu          sync.Mutex
+	limits      LimitsConfig
+	conns       int
+	procs       int
+	clientConns map[string]int
+	clientProcs map[string]int
+	global      *tokenBucket
+	clients     map[string]*tokenBucket
+	credentials map[string]*tokenBucket
+	released    chan struct{}
+}
+
+func NewLimiter(limits LimitsConfig) *Limiter {
+	return &Limiter{
+		limits:      limits,
+		clientConns: make(map[string]int),
+		clientProcs: make(map[string]int),
+		clients:     make(map[string]*tokenBucket),
+		credentials: make(map[string]*tokenBucket),
+		released:    make(chan struct{}),
+	}
+}
+
+func (l *Limiter) SetLimits(limits LimitsConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	l.global = nil
+	clear(l.clients)
+	clear(l.credentials)
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// AcquireConnection reserves a connection slot for client, waiting up to the queue timeout for one to free up.
+func (l *Limiter) AcquireConnection(client string) (func(), error) {
+	return l.acquire(client, func(limits LimitsConfig) (*int, map[string]int, int, int) {
+		return &l.conns, l.clientConns, limits.MaxConnections, limits.MaxConnectionsPerClient
+	})
+}
+
+// AcquireProcess reserves a process slot for client, waiting up to the queue timeout for one to free up.
+func (l *Limiter) AcquireProcess(client string) (func(), error) {
+	return l.acquire(client, func(limits LimitsConfig) (*int, map[string]int, int, int) {
+		return &l.procs, l.clientProcs, limits.MaxProcesses, limits.MaxProcessesPerClient
+	})
+}
+
+func (l *Limiter) acquire(client string, slots func(LimitsConfig) (*int, map[string]int, int, int)) (func(), error) {
+	var deadline <-chan time.Time
+	for {
+		l.mu.Lock()
+		total, perClient, maxTotal, maxPerClient := slots(l.limits)
+		if (maxTotal == 0 || *total < maxTotal) && (maxPerClient == 0 || perClient[client] < maxPerClient) {
+			*total++
+			perClient[client]++
+			l.mu.Unlock()
+			var once sync.Once
+			return func() { once.Do(func() { l.release(client, slots) }) }, nil
+		}
+		released := l.released
+		if deadline == nil {
+			deadline = time.After(time.Duration(l.limits.QueueTimeout))
+		}
+		l.mu.Unlock()
+		select {
+		case <-released:
+		case <-deadline:
+			return nil, ErrRateLimited
+		}
+	}
+}
+
+func (l *Limiter) release(client string, slots func(LimitsConfig) (*int, map[string]int, int, int)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	total, perClient, _, _ := slots(l.limits)
+	*total--
+	if perClient[client]--; perClient[client] <= 0 {
+		delete(perClient, client)
+	}
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// AllowRequest applies the request rate limits for client, delaying the request by at most the queue timeout.
+func (l *Limiter) AllowRequest(client string) error {
+	l.mu.Lock()
+	now := time.Now()
+	var wait time.Duration
+	var reserved []*tokenBucket
+	if l.limits.RequestsPerSecond > 0 {
+		if l.global == nil {
+			l.global = newTokenBucket(now, l.limits.Burst)
+		}
+		wait = max(wait, l.global.reserve(now, l.limits.RequestsPerSecond, l.limits.Burst))
+		reserved = append(reserved, l.global)
+	}
+	if l.limits.RequestsPerSecondPerClient > 0 {
+		l.pruneBuckets(now)
+		bucket, ok := l.clients[client]
+		if !ok {
+			bucket = newTokenBucket(now, l.limits.Burst)
+			l.clients[client] = bucket
+		}
+		wait = max(wait, bucket.reserve(now, l.limits.RequestsPerSecondPerClient, l.limits.Burst))
+		reserved = append(reserved, bucket)
+	}
+	if wait > time.Duration(l.limits.QueueTimeout) {
+		for _, bucket := range reserved {
+			bucket.cancel()
+		}
+		l.mu.Unlock()
+		return ErrRateLimited
+	}
+	l.mu.Unlock()
+	time.Sleep(wait)
+	return nil
+}
+
+// AllowCredential applies a credential's own rate limit, delaying the request by at most the queue timeout.
+func (l *Limiter) AllowCredential(name string, limit RateLimit) error {
+	if limit.RequestsPerSecond <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	bucket, ok := l.credentials[name]
+	if !ok {
+		bucket = newTokenBucket(now, limit.Burst)
+		l.credentials[name] = bucket
+	}
+	wait := bucket.reserve(now, limit.RequestsPerSecond, limit.Burst)
+	if wait > time.Duration(l.limits.QueueTimeout) {
+		bucket.cancel()
+		l.mu.Unlock()
+		return ErrRateLimited
+	}
+	l.mu.Unlock()
+	time.Sleep(wait)
+	return nil
+}
+
+func (l *Limiter) pruneBuckets(now time.Time) {
+	for client, bucket := range l.clients {
+		if now.Sub(bucket.last) > idleBucketExpiry {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// ProcessLimits returns the limits for a program whose request asked for timeout: the memory limit,
+// and timeout or the maximum runtime, whichever is shorter. A zero timeout asks for none.
+func (l LimitsConfig) ProcessLimits(timeout time.Duration) ProcessLimits {
+	maxRuntime := time.Duration(l.MaxRuntime)
+	if timeout > 0 && (maxRuntime == 0 || timeout < maxRuntime) {
+		maxRuntime = timeout
+	}
+	return ProcessLimits{MaxRuntime: maxRuntime, MaxMemory: uint64(l.MaxMemoryMB) << 20}
+}
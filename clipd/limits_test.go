@@ -0,0 +1,143 @@
+package clipd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterProcessSlots(t *testing.T) {
+	limiter := NewLimiter(LimitsConfig{MaxProcesses: 2, MaxProcessesPerClient: 1, QueueTimeout: Duration(10 * time.Millisecond)})
+	releaseA, err := limiter.AcquireProcess("a")
+	if err != nil {
+		t.Fatalf("AcquireProcess(a): %v", err)
+	}
+	if _, err := limiter.AcquireProcess("a"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("second AcquireProcess(a): err = %v, want ErrRateLimited", err)
+	}
+	releaseB, err := limiter.AcquireProcess("b")
+	if err != nil {
+		t.Fatalf("AcquireProcess(b): %v", err)
+	}
+	if _, err := limiter.AcquireProcess("c"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("AcquireProcess(c) over the global limit: err = %v, want ErrRateLimited", err)
+	}
+	releaseA()
+	// Releasing twice must not free a second slot.
+	releaseA()
+	releaseC, err := limiter.AcquireProcess("c")
+	if err != nil {
+		t.Fatalf("AcquireProcess(c) after a release: %v", err)
+	}
+	if _, err := limiter.AcquireProcess("a"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("AcquireProcess(a) with the global limit reached: err = %v, want ErrRateLimited", err)
+	}
+	releaseB()
+	releaseC()
+}
+
+func TestLimiterQueueWaitsForRelease(t *testing.T) {
+	limiter := NewLimiter(LimitsConfig{MaxConnections: 1, QueueTimeout: Duration(time.Minute)})
+	release, err := limiter.AcquireConnection("a")
+	if err != nil {
+		t.Fatalf("AcquireConnection: %v", err)
+	}
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := limiter.AcquireConnection("b")
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("AcquireConnection returned before a slot was free: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("queued AcquireConnection: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("queued AcquireConnection did not get the released slot")
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	limiter := NewLimiter(LimitsConfig{})
+	for range 100 {
+		if _, err := limiter.AcquireProcess("a"); err != nil {
+			t.Fatalf("AcquireProcess without limits: %v", err)
+		}
+		if err := limiter.AllowRequest("a"); err != nil {
+			t.Fatalf("AllowRequest without limits: %v", err)
+		}
+	}
+}
+
+func TestLimiterRequestRate(t *testing.T) {
+	limiter := NewLimiter(LimitsConfig{RequestsPerSecondPerClient: 0.001, Burst: 2})
+	for range 2 {
+		if err := limiter.AllowRequest("a"); err != nil {
+			t.Fatalf("AllowRequest within the burst: %v", err)
+		}
+	}
+	if err := limiter.AllowRequest("a"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("AllowRequest over the burst: err = %v, want ErrRateLimited", err)
+	}
+	if err := limiter.AllowRequest("b"); err != nil {
+		t.Fatalf("AllowRequest for another client: %v", err)
+	}
+	if err := limiter.AllowCredential("builds", RateLimit{RequestsPerSecond: 0.001, Burst: 1}); err != nil {
+		t.Fatalf("AllowCredential within the burst: %v", err)
+	}
+	if err := limiter.AllowCredential("builds", RateLimit{RequestsPerSecond: 0.001, Burst: 1}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("AllowCredential over the burst: err = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestLimiterSetLimitsWakesQueue(t *testing.T) {
+	limiter := NewLimiter(LimitsConfig{MaxProcesses: 1, QueueTimeout: Duration(time.Minute)})
+	if _, err := limiter.AcquireProcess("a"); err != nil {
+		t.Fatalf("AcquireProcess: %v", err)
+	}
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := limiter.AcquireProcess("b")
+		acquired <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	limiter.SetLimits(LimitsConfig{MaxProcesses: 2, QueueTimeout: Duration(time.Minute)})
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("queued AcquireProcess after raising the limit: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("raising the limit did not wake the queued AcquireProcess")
+	}
+}
+
+func TestLimitsConfigProcessLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  LimitsConfig
+		timeout time.Duration
+		want    ProcessLimits
+	}{
+		{name: "unlimited", want: ProcessLimits{}},
+		{name: "max runtime only", limits: LimitsConfig{MaxRuntime: Duration(time.Minute)}, want: ProcessLimits{MaxRuntime: time.Minute}},
+		{name: "timeout only", timeout: time.Second, want: ProcessLimits{MaxRuntime: time.Second}},
+		{name: "timeout shorter", limits: LimitsConfig{MaxRuntime: Duration(time.Minute)}, timeout: time.Second, want: ProcessLimits{MaxRuntime: time.Second}},
+		{name: "timeout longer", limits: LimitsConfig{MaxRuntime: Duration(time.Minute)}, timeout: time.Hour, want: ProcessLimits{MaxRuntime: time.Minute}},
+		{name: "memory in bytes", limits: LimitsConfig{MaxMemoryMB: 3}, want: ProcessLimits{MaxMemory: 3 << 20}},
+		{name: "output is not a process limit", limits: LimitsConfig{MaxOutputBytes: 10}, want: ProcessLimits{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.ProcessLimits(tt.timeout); got != tt.want {
+				t.Fatalf("ProcessLimits(%v) = %+v, want %+v", tt.timeout, got, tt.want)
+			}
+		})
+	}
+}
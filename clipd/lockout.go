@@ -0,0 +1,168 @@
+package clipd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxFailures  = 5
+	defaultBaseDelay    = 500 * time.Millisecond
+	defaultBanDuration  = time.Minute
+	defaultMaxBan       = time.Hour
+	lockoutForgetPeriod = 24 * time.Hour
+)
+
+type LockoutError struct {
+	Until time.Time
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("too many failed authentication attempts, retry after %s", e.Until.Format(time.RFC3339))
+}
+
+type Ban struct {
+	Client   string    `json:"client"`
+	Failures int       `json:"failures"`
+	Bans     int       `json:"bans"`
+	Until    time.Time `json:"until"`
+	Banned   bool      `json:"banned"`
+}
+
+type authRecord struct {
+	failures    int
+	bans        int
+	lastFailure time.Time
+	until       time.Time
+	banned      bool
+}
+
+// AuthGuard tracks failed authentications per client. Each failure delays the next attempt exponentially,
+// and reaching the failure limit bans the client for a period that doubles with every repeat ban.
+type AuthGuard struct {
+	mu        sync.Mutex
+	config    LockoutConfig
+	allowlist AddressSet
+	records   map[string]*authRecord
+	now       func() time.Time
+}
+
+func NewAuthGuard(config LockoutConfig) (*AuthGuard, error) {
+	allowlist, err := ParseAddressSet(config.Allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lockout allowlist: %w", err)
+	}
+	return &AuthGuard{config: config, allowlist: allowlist, records: make(map[string]*authRecord), now: time.Now}, nil
+}
+
+func (g *AuthGuard) SetConfig(config LockoutConfig) error {
+	allowlist, err := ParseAddressSet(config.Allowlist)
+	if err != nil {
+		return fmt.Errorf("invalid lockout allowlist: %w", err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.config = config
+	g.allowlist = allowlist
+	return nil
+}
+
+// Check returns a *LockoutError if client must not attempt to authenticate yet.
+func (g *AuthGuard) Check(client string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.allowlist.Contains(client) {
+		return nil
+	}
+	record, ok := g.records[client]
+	if !ok || !g.now().Before(record.until) {
+		return nil
+	}
+	return &LockoutError{Until: record.until}
+}
+
+// Failure records a failed authentication and reports whether it resulted in a new ban.
+func (g *AuthGuard) Failure(client string) (Ban, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.allowlist.Contains(client) {
+		return Ban{Client: client}, false
+	}
+	now := g.now()
+	g.forget(now)
+	record, ok := g.records[client]
+	if !ok {
+		record = &authRecord{}
+		g.records[client] = record
+	}
+	record.failures++
+	record.lastFailure = now
+	record.banned = false
+	maxFailures := g.config.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = defaultMaxFailures
+	}
+	if record.failures >= maxFailures {
+		record.bans++
+		record.failures = 0
+		record.banned = true
+		record.until = now.Add(backoff(g.config.BanDuration, defaultBanDuration, record.bans, g.config.MaxBan))
+		return record.ban(client), true
+	}
+	record.until = now.Add(backoff(g.config.BaseDelay, defaultBaseDelay, record.failures, g.config.MaxBan))
+	return record.ban(client), false
+}
+
+// Success clears the pending failures of client. Earlier bans still count towards escalation.
+func (g *AuthGuard) Success(client string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if record, ok := g.records[client]; ok {
+		record.failures = 0
+		record.until = time.Time{}
+	}
+}
+
+// Bans lists clients that are currently banned or delayed.
+func (g *AuthGuard) Bans() []Ban {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	bans := []Ban{}
+	for client, record := range g.records {
+		if now.Before(record.until) {
+			bans = append(bans, record.ban(client))
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+func (g *AuthGuard) forget(now time.Time) {
+	for client, record := range g.records {
+		if now.Sub(record.lastFailure) > lockoutForgetPeriod && now.After(record.until) {
+			delete(g.records, client)
+		}
+	}
+}
+
+func (r *authRecord) ban(client string) Ban {
+	return Ban{Client: client, Failures: r.failures, Bans: r.bans, Until: r.until, Banned: r.banned}
+}
+
+func backoff(base Duration, fallback time.Duration, attempt int, limit Duration) time.Duration {
+	d := time.Duration(base)
+	if d <= 0 {
+		d = fallback
+	}
+	ceiling := time.Duration(limit)
+	if ceiling <= 0 {
+		ceiling = defaultMaxBan
+	}
+	for i := 1; i < attempt && d < ceiling; i++ {
+		d *= 2
+	}
+	return min(d, ceiling)
+}
@@ -0,0 +1,117 @@
+package clipd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testClock is a manually advanced clock for AuthGuard.
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestGuard(t *testing.T, config LockoutConfig) (*AuthGuard, *testClock) {
+	t.Helper()
+	guard, err := NewAuthGuard(config)
+	if err != nil {
+		t.Fatalf("NewAuthGuard: %v", err)
+	}
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	guard.now = func() time.Time { return clock.now }
+	return guard, clock
+}
+
+func TestAuthGuardBackoff(t *testing.T) {
+	guard, clock := newTestGuard(t, LockoutConfig{
+		MaxFailures: 3,
+		BaseDelay:   Duration(time.Second),
+		BanDuration: Duration(time.Minute),
+		MaxBan:      Duration(3 * time.Minute),
+	})
+	const client = "192.0.2.1"
+	start := clock.now
+	for i, want := range []time.Duration{time.Second, 2 * time.Second} {
+		ban, banned := guard.Failure(client)
+		if banned || ban.Until != clock.now.Add(want) {
+			t.Fatalf("failure %d: got %+v banned=%v, want a delay of %s", i+1, ban, banned, want)
+		}
+	}
+	var lockout *LockoutError
+	if err := guard.Check(client); !errors.As(err, &lockout) || lockout.Until != start.Add(2*time.Second) {
+		t.Fatalf("Check while delayed: err = %v, want a lockout until %s", err, start.Add(2*time.Second))
+	}
+	clock.advance(2 * time.Second)
+	if err := guard.Check(client); err != nil {
+		t.Fatalf("Check once the delay expired: %v", err)
+	}
+
+	// Each repeat ban doubles, up to MaxBan.
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		if i > 0 {
+			guard.Failure(client)
+			guard.Failure(client)
+		}
+		ban, banned := guard.Failure(client)
+		if !banned || ban.Bans != i+1 || ban.Until != clock.now.Add(want) {
+			t.Fatalf("ban %d: got %+v banned=%v, want a ban of %s", i+1, ban, banned, want)
+		}
+		if err := guard.Check(client); err == nil {
+			t.Fatalf("Check during ban %d succeeded", i+1)
+		}
+		clock.advance(want)
+		if err := guard.Check(client); err != nil {
+			t.Fatalf("Check after ban %d expired: %v", i+1, err)
+		}
+	}
+}
+
+func TestAuthGuardSuccessResets(t *testing.T) {
+	guard, clock := newTestGuard(t, LockoutConfig{MaxFailures: 2, BaseDelay: Duration(time.Second), BanDuration: Duration(time.Minute)})
+	const client = "192.0.2.1"
+	guard.Failure(client)
+	guard.Success(client)
+	if err := guard.Check(client); err != nil {
+		t.Fatalf("Check after a success: %v", err)
+	}
+	if ban, banned := guard.Failure(client); banned || ban.Failures != 1 {
+		t.Fatalf("failure after a success: got %+v banned=%v, want failure 1 without a ban", ban, banned)
+	}
+	if bans := guard.Bans(); len(bans) != 1 || bans[0].Client != client {
+		t.Fatalf("Bans() = %+v, want only %s", bans, client)
+	}
+	clock.advance(time.Second)
+	if bans := guard.Bans(); len(bans) != 0 {
+		t.Fatalf("Bans() after the delay expired = %+v, want none", bans)
+	}
+}
+
+func TestAuthGuardAllowlist(t *testing.T) {
+	guard, _ := newTestGuard(t, LockoutConfig{MaxFailures: 1, Allowlist: []string{"192.168.1.0/24"}})
+	for range 5 {
+		if _, banned := guard.Failure("192.168.1.20"); banned {
+			t.Fatalf("allowlisted client was banned")
+		}
+	}
+	if err := guard.Check("192.168.1.20"); err != nil {
+		t.Fatalf("Check for an allowlisted client: %v", err)
+	}
+	if _, banned := guard.Failure("192.168.2.20"); !banned {
+		t.Fatalf("client outside the allowlist was not banned")
+	}
+}
+
+func TestAuthGuardForgetsOldFailures(t *testing.T) {
+	guard, clock := newTestGuard(t, LockoutConfig{MaxFailures: 2, BaseDelay: Duration(time.Second), BanDuration: Duration(time.Minute)})
+	guard.Failure("192.0.2.1")
+	clock.advance(lockoutForgetPeriod + time.Second)
+	// Recording a failure from another client forgets the stale record.
+	guard.Failure("192.0.2.2")
+	if ban, banned := guard.Failure("192.0.2.1"); banned || ban.Failures != 1 {
+		t.Fatalf("failure after the forget period: got %+v banned=%v, want failure 1 without a ban", ban, banned)
+	}
+}
@@ -0,0 +1,199 @@
+package clipd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the request duration histogram.
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range LatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Metrics collects server counters and exposes them in the Prometheus text format.
+type Metrics struct {
+	mu                sync.Mutex
+	requests          map[[2]string]uint64
+	latency           map[string]*histogram
+	authFailures      uint64
+	bytesReceived     uint64
+	activeConnections int64
+	rejected          uint64
+	processesSpawned  uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: make(map[[2]string]uint64),
+		latency:  make(map[string]*histogram),
+	}
+}
+
+func (m *Metrics) RequestDone(requestType, result string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[[2]string{requestType, result}]++
+	h, ok := m.latency[requestType]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(LatencyBuckets))}
+		m.latency[requestType] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func (m *Metrics) AuthFailure() {
+	m.mu.Lock()
+	m.authFailures++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) BytesReceived(n int) {
+	m.mu.Lock()
+	m.bytesReceived += uint64(n)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ConnectionOpened() {
+	m.mu.Lock()
+	m.activeConnections++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ConnectionClosed() {
+	m.mu.Lock()
+	m.activeConnections--
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ConnectionRejected() {
+	m.mu.Lock()
+	m.rejected++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ProcessSpawned() {
+	m.mu.Lock()
+	m.processesSpawned++
+	m.mu.Unlock()
+}
+
+type RequestCount struct {
+	Type   string `json:"type"`
+	Result string `json:"result"`
+	Count  uint64 `json:"count"`
+}
+
+type LatencySummary struct {
+	Type         string  `json:"type"`
+	Count        uint64  `json:"count"`
+	TotalSeconds float64 `json:"totalSeconds"`
+}
+
+type MetricsSnapshot struct {
+	Requests          []RequestCount   `json:"requests"`
+	Latency           []LatencySummary `json:"latency"`
+	AuthFailures      uint64           `json:"authFailures"`
+	BytesReceived     uint64           `json:"bytesReceived"`
+	ActiveConnections int64            `json:"activeConnections"`
+	RejectedConns     uint64           `json:"rejectedConnections"`
+	ProcessesSpawned  uint64           `json:"processesSpawned"`
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := MetricsSnapshot{
+		Requests:          []RequestCount{},
+		Latency:           []LatencySummary{},
+		AuthFailures:      m.authFailures,
+		BytesReceived:     m.bytesReceived,
+		ActiveConnections: m.activeConnections,
+		RejectedConns:     m.rejected,
+		ProcessesSpawned:  m.processesSpawned,
+	}
+	for key, count := range m.requests {
+		snapshot.Requests = append(snapshot.Requests, RequestCount{Type: key[0], Result: key[1], Count: count})
+	}
+	sort.Slice(snapshot.Requests, func(i, j int) bool {
+		a, b := snapshot.Requests[i], snapshot.Requests[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Result < b.Result
+	})
+	for _, t := range sortedKeys(m.latency) {
+		h := m.latency[t]
+		snapshot.Latency = append(snapshot.Latency, LatencySummary{Type: t, Count: h.count, TotalSeconds: h.sum})
+	}
+	return snapshot
+}
+
+// WritePrometheus writes all metrics to w in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	snapshot := m.Snapshot()
+	var sb strings.Builder
+	sb.WriteString("# HELP clipd_requests_total Requests handled, by type and result.\n")
+	sb.WriteString("# TYPE clipd_requests_total counter\n")
+	for _, r := range snapshot.Requests {
+		fmt.Fprintf(&sb, "clipd_requests_total{type=%s,result=%s} %d\n", quoteLabel(r.Type), quoteLabel(r.Result), r.Count)
+	}
+	sb.WriteString("# HELP clipd_request_duration_seconds Time spent handling requests, by type.\n")
+	sb.WriteString("# TYPE clipd_request_duration_seconds histogram\n")
+	m.mu.Lock()
+	for _, t := range sortedKeys(m.latency) {
+		h := m.latency[t]
+		for i, bound := range LatencyBuckets {
+			fmt.Fprintf(&sb, "clipd_request_duration_seconds_bucket{type=%s,le=\"%g\"} %d\n", quoteLabel(t), bound, h.counts[i])
+		}
+		fmt.Fprintf(&sb, "clipd_request_duration_seconds_bucket{type=%s,le=\"+Inf\"} %d\n", quoteLabel(t), h.count)
+		fmt.Fprintf(&sb, "clipd_request_duration_seconds_sum{type=%s} %g\n", quoteLabel(t), h.sum)
+		fmt.Fprintf(&sb, "clipd_request_duration_seconds_count{type=%s} %d\n", quoteLabel(t), h.count)
+	}
+	m.mu.Unlock()
+	writeScalar(&sb, "clipd_auth_failures_total", "counter", "Requests rejected because authentication failed.", float64(snapshot.AuthFailures))
+	writeScalar(&sb, "clipd_received_bytes_total", "counter", "Bytes read from client connections.", float64(snapshot.BytesReceived))
+	writeScalar(&sb, "clipd_active_connections", "gauge", "Client connections currently open.", float64(snapshot.ActiveConnections))
+	writeScalar(&sb, "clipd_rejected_connections_total", "counter", "Connections refused by the address allow and deny lists.", float64(snapshot.RejectedConns))
+	writeScalar(&sb, "clipd_processes_spawned_total", "counter", "Processes started on behalf of clients.", float64(snapshot.ProcessesSpawned))
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// labelEscaper escapes label values the way the Prometheus text format expects, which differs from Go
+// quoting for other control and non-ASCII characters.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func writeScalar(sb *strings.Builder, name, kind, help string, value float64) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
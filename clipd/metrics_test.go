@@ -0,0 +1,58 @@
+package clipd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.RequestDone("run", AuditResultOK, 250*time.Millisecond)
+	metrics.RequestDone("run", AuditResultOK, 2*time.Second)
+	metrics.RequestDone("run", AuditResultForbidden, 500*time.Millisecond)
+	metrics.RequestDone("odd\"type\\\n", AuditResultError, 20*time.Second)
+	metrics.AuthFailure()
+	metrics.BytesReceived(512)
+	metrics.ConnectionOpened()
+	metrics.ProcessSpawned()
+	var sb strings.Builder
+	if err := metrics.WritePrometheus(&sb); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	output := sb.String()
+	for _, want := range []string{
+		"# TYPE clipd_requests_total counter\n",
+		`clipd_requests_total{type="run",result="ok"} 2` + "\n",
+		`clipd_requests_total{type="run",result="forbidden"} 1` + "\n",
+		`clipd_requests_total{type="odd\"type\\\n",result="error"} 1` + "\n",
+		"# TYPE clipd_request_duration_seconds histogram\n",
+		`clipd_request_duration_seconds_bucket{type="run",le="0.1"} 0` + "\n",
+		`clipd_request_duration_seconds_bucket{type="run",le="0.25"} 1` + "\n",
+		`clipd_request_duration_seconds_bucket{type="run",le="0.5"} 2` + "\n",
+		`clipd_request_duration_seconds_bucket{type="run",le="1"} 2` + "\n",
+		`clipd_request_duration_seconds_bucket{type="run",le="2.5"} 3` + "\n",
+		`clipd_request_duration_seconds_bucket{type="run",le="+Inf"} 3` + "\n",
+		`clipd_request_duration_seconds_sum{type="run"} 2.75` + "\n",
+		`clipd_request_duration_seconds_count{type="run"} 3` + "\n",
+		`clipd_request_duration_seconds_bucket{type="odd\"type\\\n",le="10"} 0` + "\n",
+		`clipd_request_duration_seconds_bucket{type="odd\"type\\\n",le="+Inf"} 1` + "\n",
+		"# TYPE clipd_auth_failures_total counter\nclipd_auth_failures_total 1\n",
+		"clipd_received_bytes_total 512\n",
+		"# TYPE clipd_active_connections gauge\nclipd_active_connections 1\n",
+		"clipd_rejected_connections_total 0\n",
+		"clipd_processes_spawned_total 1\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output is missing %q", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("output:\n%s", output)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if !strings.HasPrefix(line, "# ") && len(strings.Fields(line)) != 2 {
+			t.Errorf("malformed sample line %q", line)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package clipd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// PolicyRule matches a run or pipe request. Program is a case-insensitive glob against the resolved
+// executable path, or against its file name when the pattern has no path separator. When Args or
+// ArgsRegex are set every argument must match one of them, and when WorkingDirs is set the working
+// directory must be inside one of the listed directories. When Verbs is set the rule only matches
+// run requests using one of those shell verbs, and an allow rule only matches runas requests, which
+// start the program elevated, if it lists runas. Requests allowed by a rule with RequireApproval only
+// run once someone on the server confirms them.
+type PolicyRule struct {
+	Name            string   `json:"name,omitempty"`
+	Action          string   `json:"action"`
+	Program         string   `json:"program,omitempty"`
+	Args            []string `json:"args,omitempty"`
+	ArgsRegex       []string `json:"argsRegex,omitempty"`
+	WorkingDirs     []string `json:"workingDirs,omitempty"`
+	Verbs           []string `json:"verbs,omitempty"`
+	RequireApproval bool     `json:"requireApproval,omitempty"`
+
+	program *regexp.Regexp
+	args    []*regexp.Regexp
+}
+
+// Policy is an ordered list of rules where the first match decides. Requests matching no rule get Default,
+// except runas requests, which are denied unless a rule allows them.
+// ProtectedEnv lists environment variables, as case-insensitive globs, that requests may not set.
+type Policy struct {
+	Default      string       `json:"default,omitempty"`
+	Rules        []PolicyRule `json:"rules"`
+	ProtectedEnv []string     `json:"protectedEnv,omitempty"`
+
+	protectedEnv []*regexp.Regexp
+}
+
+type PolicyDecision struct {
+	Allowed bool   `json:"allowed"`
+	Program string `json:"program"`
+	Rule    int    `json:"rule,omitempty"`
+	Name    string `json:"name,omitempty"`
+	// RequireApproval is set when the request may only run after someone on the server confirms it.
+	RequireApproval bool `json:"requireApproval,omitempty"`
+}
+
+func (d PolicyDecision) String() string {
+	action := PolicyDeny
+	if d.Allowed {
+		action = PolicyAllow
+	}
+	if d.RequireApproval {
+		action += " with approval"
+	}
+	if d.Rule == 0 {
+		return fmt.Sprintf("%s by default policy", action)
+	}
+	return fmt.Sprintf("%s by rule %d (%s)", action, d.Rule, d.Name)
+}
+
+// PolicyError is returned for requests the policy denies.
+type PolicyError struct {
+	Decision PolicyDecision
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("policy denies running %s: %s", e.Decision.Program, e.Decision)
+}
+
+// EnvPolicyError is returned for requests setting an environment variable the policy protects.
+type EnvPolicyError struct {
+	Name string
+}
+
+func (e *EnvPolicyError) Error() string {
+	return fmt.Sprintf("policy forbids setting environment variable %s", e.Name)
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+	if err := policy.compile(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return &policy, nil
+}
+
+func (p *Policy) compile() error {
+	if p.Default == "" {
+		p.Default = PolicyDeny
+	}
+	if p.Default != PolicyAllow && p.Default != PolicyDeny {
+		return fmt.Errorf("default must be %q or %q", PolicyAllow, PolicyDeny)
+	}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("%s: %w", rule.Name, err)
+		}
+	}
+	for _, pattern := range p.ProtectedEnv {
+		p.protectedEnv = append(p.protectedEnv, globRegexp(pattern))
+	}
+	return nil
+}
+
+func (r *PolicyRule) compile() error {
+	if r.Action != PolicyAllow && r.Action != PolicyDeny {
+		return fmt.Errorf("action must be %q or %q", PolicyAllow, PolicyDeny)
+	}
+	if r.Program != "" {
+		r.program = globRegexp(r.Program)
+	}
+	for _, verb := range r.Verbs {
+		if !slices.Contains(shellVerbs, strings.ToLower(verb)) {
+			return fmt.Errorf("unknown verb %q, expected one of %s", verb, strings.Join(shellVerbs, ", "))
+		}
+	}
+	for _, pattern := range r.Args {
+		r.args = append(r.args, globRegexp(pattern))
+	}
+	for _, pattern := range r.ArgsRegex {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid argsRegex %q: %w", pattern, err)
+		}
+		r.args = append(r.args, re)
+	}
+	return nil
+}
+
+// Evaluate decides whether program, which should already be resolved to its full path, may run with
+// the shell verb of the request, if any. A nil policy allows everything except runas.
+func (p *Policy) Evaluate(program string, args []string, workingDir string, verb string) PolicyDecision {
+	verb = strings.ToLower(verb)
+	if p == nil {
+		return PolicyDecision{Allowed: verb != VerbRunAs, Program: program}
+	}
+	for i, rule := range p.Rules {
+		if rule.matches(program, args, workingDir, verb) {
+			allowed := rule.Action == PolicyAllow
+			return PolicyDecision{Allowed: allowed, Program: program, Rule: i + 1, Name: rule.Name, RequireApproval: allowed && rule.RequireApproval}
+		}
+	}
+	return PolicyDecision{Allowed: p.Default == PolicyAllow && verb != VerbRunAs, Program: program}
+}
+
+// Check returns the decision for the request, and a *PolicyError if the policy denies it.
+func (p *Policy) Check(program string, args []string, workingDir string, verb string) (PolicyDecision, error) {
+	decision := p.Evaluate(program, args, workingDir, verb)
+	if !decision.Allowed {
+		return decision, &PolicyError{Decision: decision}
+	}
+	return decision, nil
+}
+
+// ProtectsEnv reports whether requests may not set the environment variable name. A nil policy
+// protects nothing.
+func (p *Policy) ProtectsEnv(name string) bool {
+	return p != nil && matchesAny(p.protectedEnv, name)
+}
+
+// CheckEnv returns an *EnvPolicyError if env sets a protected variable.
+func (p *Policy) CheckEnv(env map[string]string) error {
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		if p.ProtectsEnv(name) {
+			return &EnvPolicyError{Name: name}
+		}
+	}
+	return nil
+}
+
+func (r *PolicyRule) matches(program string, args []string, workingDir string, verb string) bool {
+	if len(r.Verbs) > 0 {
+		if !slices.ContainsFunc(r.Verbs, func(v string) bool { return strings.EqualFold(v, verb) }) {
+			return false
+		}
+	} else if verb == VerbRunAs && r.Action == PolicyAllow {
+		return false
+	}
+	if r.program != nil {
+		target := program
+		if !strings.ContainsAny(r.Program, `\/`) {
+			target = windowsBase(program)
+		}
+		if !r.program.MatchString(target) {
+			return false
+		}
+	}
+	if len(r.args) > 0 {
+		for _, arg := range args {
+			if !matchesAny(r.args, arg) {
+				return false
+			}
+		}
+	}
+	if len(r.WorkingDirs) > 0 {
+		inside := false
+		for _, dir := range r.WorkingDirs {
+			inside = inside || hasPathPrefix(workingDir, dir)
+		}
+		if !inside {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp compiles a case-insensitive glob where * matches any run of characters, including
+// path separators, and ? matches a single character.
+func globRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+func windowsBase(path string) string {
+	if i := strings.LastIndexAny(path, `\/:`); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+// hasPathPrefix reports whether the Windows path is dir or inside it, ignoring case and separator style.
+func hasPathPrefix(path, dir string) bool {
+	path = strings.ToLower(strings.ReplaceAll(path, "/", `\`))
+	dir = strings.TrimRight(strings.ToLower(strings.ReplaceAll(dir, "/", `\`)), `\`)
+	if dir == "" {
+		return false
+	}
+	return path == dir || strings.HasPrefix(path, dir+`\`)
+}
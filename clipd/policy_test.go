@@ -0,0 +1,101 @@
+package clipd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Name: "no registry", Action: PolicyDeny, Program: "regedit.exe"},
+		{Name: "git", Action: PolicyAllow, Program: "git.exe", Args: []string{"status", "log", "--*"}},
+		{Name: "tools", Action: PolicyAllow, Program: `C:\Tools\*`},
+		{Name: "build", Action: PolicyAllow, Program: "make.exe", ArgsRegex: []string{"[a-z]+"}, WorkingDirs: []string{`C:\work`}},
+		{Name: "notepad", Action: PolicyAllow, Program: "notepad.exe", RequireApproval: true},
+		{Name: "everything else in tools", Action: PolicyDeny, Program: `C:\Tools\*`},
+		{Name: "elevated installers", Action: PolicyAllow, Program: "setup.exe", Verbs: []string{"RunAs"}},
+		{Name: "printing", Action: PolicyAllow, Program: "*.pdf", Verbs: []string{"print"}},
+		{Name: "no elevated cmd", Action: PolicyDeny, Program: "cmd.exe"},
+	}}
+	if err := policy.compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	tests := []struct {
+		name     string
+		program  string
+		args     []string
+		wd       string
+		verb     string
+		allowed  bool
+		rule     int
+		approval bool
+	}{
+		{name: "denied by basename", program: `C:\Windows\regedit.exe`, allowed: false, rule: 1},
+		{name: "basename ignores case", program: `C:\Windows\REGEDIT.EXE`, allowed: false, rule: 1},
+		{name: "all args match", program: `C:\Git\bin\git.exe`, args: []string{"log", "--oneline"}, allowed: true, rule: 2},
+		{name: "no args", program: `C:\Git\bin\git.exe`, allowed: true, rule: 2},
+		{name: "one arg does not match", program: `C:\Git\bin\git.exe`, args: []string{"status", "push"}, allowed: false},
+		{name: "full path glob", program: `C:\Tools\bin\x.exe`, allowed: true, rule: 3},
+		{name: "full path glob needs the path", program: `D:\Tools\x.exe`, allowed: false},
+		{name: "first match wins", program: `C:\Tools\regedit.exe`, allowed: false, rule: 1},
+		{name: "argsRegex matches whole argument", program: `C:\bin\make.exe`, args: []string{"all"}, wd: `C:\work\src`, allowed: true, rule: 4},
+		{name: "argsRegex is anchored", program: `C:\bin\make.exe`, args: []string{"all; rm"}, wd: `C:\work`, allowed: false},
+		{name: "argsRegex case", program: `C:\bin\make.exe`, args: []string{"ALL"}, wd: `C:\work`, allowed: false},
+		{name: "outside working dirs", program: `C:\bin\make.exe`, args: []string{"all"}, wd: `C:\workshop`, allowed: false},
+		{name: "approval", program: `C:\Windows\notepad.exe`, allowed: true, rule: 5, approval: true},
+		{name: "default", program: `C:\Windows\calc.exe`, allowed: false},
+		{name: "rule without verbs matches other verbs", program: `C:\Windows\notepad.exe`, verb: "edit", allowed: true, rule: 5, approval: true},
+		{name: "runas needs an allow rule listing it", program: `C:\Tools\x.exe`, verb: "runas", allowed: false, rule: 6},
+		{name: "runas allowed by opt-in", program: `D:\setup.exe`, verb: "RUNAS", allowed: true, rule: 7},
+		{name: "verbs rule needs the verb", program: `D:\setup.exe`, allowed: false},
+		{name: "print verb", program: `D:\doc.pdf`, verb: "print", allowed: true, rule: 8},
+		{name: "other verb", program: `D:\doc.pdf`, verb: "open", allowed: false},
+		{name: "deny rule applies to runas", program: `C:\Windows\cmd.exe`, verb: "runas", allowed: false, rule: 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.Evaluate(tt.program, tt.args, tt.wd, tt.verb)
+			if got.Allowed != tt.allowed || got.Rule != tt.rule || got.RequireApproval != tt.approval {
+				t.Fatalf("Evaluate(%q, %q, %q, %q) = %+v, want allowed=%v rule=%d approval=%v", tt.program, tt.args, tt.wd, tt.verb, got, tt.allowed, tt.rule, tt.approval)
+			}
+		})
+	}
+}
+
+func TestPolicyDefault(t *testing.T) {
+	allow := &Policy{Default: PolicyAllow, Rules: []PolicyRule{{Action: PolicyDeny, Program: "cmd.exe"}}}
+	if err := allow.compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if decision := allow.Evaluate(`C:\Windows\notepad.exe`, nil, "", ""); !decision.Allowed || decision.Rule != 0 {
+		t.Fatalf("default allow: got %+v", decision)
+	}
+	if decision := allow.Evaluate(`C:\Windows\notepad.exe`, nil, "", VerbRunAs); decision.Allowed {
+		t.Fatalf("default allow allowed runas: got %+v", decision)
+	}
+	if allow.Rules[0].Name != "rule 1" {
+		t.Fatalf("unnamed rule got name %q, want %q", allow.Rules[0].Name, "rule 1")
+	}
+	_, err := allow.Check(`C:\Windows\System32\cmd.exe`, nil, "", "")
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Decision.Rule != 1 {
+		t.Fatalf("Check of a denied program: err = %v, want a *PolicyError for rule 1", err)
+	}
+	var nilPolicy *Policy
+	if decision := nilPolicy.Evaluate(`C:\x.exe`, nil, "", VerbPrint); !decision.Allowed {
+		t.Fatalf("a nil policy denied %+v", decision)
+	}
+	if decision := nilPolicy.Evaluate(`C:\x.exe`, nil, "", VerbRunAs); decision.Allowed {
+		t.Fatalf("a nil policy allowed runas")
+	}
+	for _, invalid := range []*Policy{
+		{Default: "maybe"},
+		{Rules: []PolicyRule{{Action: "permit"}}},
+		{Rules: []PolicyRule{{Action: PolicyAllow, ArgsRegex: []string{"("}}}},
+		{Rules: []PolicyRule{{Action: PolicyAllow, Verbs: []string{"sudo"}}}},
+	} {
+		if err := invalid.compile(); err == nil {
+			t.Errorf("compile of %+v succeeded", invalid)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package clipd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Process states reported in ProcessInfo.State.
+const (
+	ProcessRunning = "running"
+	ProcessExited  = "exited"
+)
+
+// maxExitedProcesses is how many exited processes a ProcessTable remembers for wait and ps.
+const maxExitedProcesses = 100
+
+// ErrNoSuchProcess is returned for process IDs that are unknown, forgotten or started by another credential.
+var ErrNoSuchProcess = errors.New("no such process")
+
+// ProcessInfo describes a program the server started for a client.
+type ProcessInfo struct {
+	ID         int       `json:"id"`
+	PID        int       `json:"pid"`
+	Type       string    `json:"type"`
+	Program    string    `json:"program"`
+	Args       []string  `json:"args,omitempty"`
+	Client     string    `json:"client"`
+	Credential string    `json:"credential"`
+	Started    time.Time `json:"started"`
+	State      string    `json:"state"`
+	ExitCode   *int      `json:"exitCode,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Ended      time.Time `json:"ended,omitzero"`
+}
+
+// Status returns how the process exited. It is only meaningful once the process has exited.
+func (p ProcessInfo) Status() ExitStatus {
+	status := ExitStatus{Reason: p.Reason}
+	if p.ExitCode != nil {
+		status.Code = *p.ExitCode
+	}
+	return status
+}
+
+// ProcessTable tracks the programs the server started, so clients can list, stop and wait for them.
+// It remembers the most recent exited processes so that their exit codes can still be collected.
+type ProcessTable struct {
+	mu      sync.Mutex
+	nextID  int
+	entries map[int]*processEntry
+	// exited holds the IDs of exited processes, oldest first.
+	exited []int
+}
+
+type processEntry struct {
+	info   ProcessInfo
+	kill   func() error
+	killed bool
+	done   chan struct{}
+}
+
+func NewProcessTable() *ProcessTable {
+	return &ProcessTable{entries: make(map[int]*processEntry)}
+}
+
+// Add records a running process, which kill stops, and returns the ID it was given.
+func (t *ProcessTable) Add(info ProcessInfo, kill func() error) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	info.ID = t.nextID
+	info.State = ProcessRunning
+	t.entries[info.ID] = &processEntry{info: info, kill: kill, done: make(chan struct{})}
+	return info.ID
+}
+
+// Exited records that the process with id exited with status, waking anyone waiting for it. A process
+// stopped through Kill is reported as killed unless it hit a limit.
+func (t *ProcessTable) Exited(id int, status ExitStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.entries[id]
+	if !ok || entry.info.State == ProcessExited {
+		return
+	}
+	if status.Reason == "" && entry.killed {
+		status.Reason = ExitKilled
+	}
+	entry.info.State = ProcessExited
+	entry.info.ExitCode = &status.Code
+	entry.info.Reason = status.Reason
+	entry.info.Ended = time.Now()
+	entry.kill = nil
+	close(entry.done)
+	t.exited = append(t.exited, id)
+	for len(t.exited) > maxExitedProcesses {
+		delete(t.entries, t.exited[0])
+		t.exited = t.exited[1:]
+	}
+}
+
+// List returns the processes visible to credential, ordered by ID. An empty credential sees them all.
+func (t *ProcessTable) List(credential string) []ProcessInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := []ProcessInfo{}
+	for _, entry := range t.entries {
+		if visible(entry, credential) {
+			list = append(list, entry.info)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Kill stops the process with id and every process it started. The process is reported as exited once
+// the server notices, which Wait can be used to wait for.
+func (t *ProcessTable) Kill(id int, credential string) (ProcessInfo, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, err := t.lookup(id, credential)
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	if entry.info.State == ProcessExited {
+		return entry.info, fmt.Errorf("process %d has already exited", id)
+	}
+	// The kill function runs under the lock so that it cannot race with the process exiting and the
+	// server releasing its handles.
+	if err := entry.kill(); err != nil {
+		return entry.info, fmt.Errorf("failed to kill process %d: %w", id, err)
+	}
+	entry.killed = true
+	return entry.info, nil
+}
+
+// Wait blocks until the process with id has exited or cancel is closed, and returns its final state.
+func (t *ProcessTable) Wait(id int, credential string, cancel <-chan struct{}) (ProcessInfo, error) {
+	t.mu.Lock()
+	entry, err := t.lookup(id, credential)
+	t.mu.Unlock()
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	select {
+	case <-entry.done:
+	case <-cancel:
+		return ProcessInfo{}, fmt.Errorf("stopped waiting for process %d", id)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return entry.info, nil
+}
+
+func (t *ProcessTable) lookup(id int, credential string) (*processEntry, error) {
+	entry, ok := t.entries[id]
+	if !ok || !visible(entry, credential) {
+		return nil, fmt.Errorf("%w: %d", ErrNoSuchProcess, id)
+	}
+	return entry, nil
+}
+
+func visible(entry *processEntry, credential string) bool {
+	return credential == "" || entry.info.Credential == credential
+}
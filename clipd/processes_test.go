@@ -0,0 +1,71 @@
+package clipd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProcessTableKillAndWait(t *testing.T) {
+	table := NewProcessTable()
+	var id int
+	kills := 0
+	id = table.Add(ProcessInfo{PID: 10, Program: "a.exe", Credential: "builds"}, func() error {
+		kills++
+		// The server reports the exit from another goroutine once the process is gone.
+		go table.Exited(id, ExitStatus{Code: 1})
+		return nil
+	})
+	other := table.Add(ProcessInfo{PID: 11, Program: "b.exe", Credential: "default"}, func() error { return nil })
+
+	if list := table.List("builds"); len(list) != 1 || list[0].ID != id || list[0].State != ProcessRunning {
+		t.Fatalf("List(builds) = %+v, want only process %d running", list, id)
+	}
+	if list := table.List(""); len(list) != 2 {
+		t.Fatalf("List() returned %d processes, want 2", len(list))
+	}
+	if _, err := table.Kill(other, "builds"); !errors.Is(err, ErrNoSuchProcess) {
+		t.Fatalf("Kill of another credential's process: err = %v, want ErrNoSuchProcess", err)
+	}
+	if _, err := table.Kill(id, "builds"); err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	info, err := table.Wait(id, "builds", nil)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if info.State != ProcessExited || info.Status() != (ExitStatus{Code: 1, Reason: ExitKilled}) {
+		t.Fatalf("Wait = %+v, want exited and killed with code 1", info)
+	}
+	if _, err := table.Kill(id, "builds"); err == nil {
+		t.Fatalf("Kill of an exited process succeeded")
+	}
+	if kills != 1 {
+		t.Fatalf("kill function called %d times, want 1", kills)
+	}
+}
+
+func TestProcessTableWaitCancel(t *testing.T) {
+	table := NewProcessTable()
+	id := table.Add(ProcessInfo{PID: 10}, func() error { return nil })
+	cancel := make(chan struct{})
+	time.AfterFunc(10*time.Millisecond, func() { close(cancel) })
+	if _, err := table.Wait(id, "", cancel); err == nil {
+		t.Fatalf("Wait returned without the process exiting")
+	}
+}
+
+func TestProcessTableForgetsOldExits(t *testing.T) {
+	table := NewProcessTable()
+	first := table.Add(ProcessInfo{}, nil)
+	table.Exited(first, ExitStatus{})
+	for range maxExitedProcesses {
+		table.Exited(table.Add(ProcessInfo{}, nil), ExitStatus{})
+	}
+	if _, err := table.Wait(first, "", nil); !errors.Is(err, ErrNoSuchProcess) {
+		t.Fatalf("Wait for a forgotten process: err = %v, want ErrNoSuchProcess", err)
+	}
+	if n := len(table.List("")); n != maxExitedProcesses {
+		t.Fatalf("table remembers %d processes, want %d", n, maxExitedProcesses)
+	}
+}
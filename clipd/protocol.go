@@ -1,13 +1,64 @@
 package clipd
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 type RequestType int
 
 const (
 	RequestTypeClipboard RequestType = iota
 	RequestTypeRun
 	RequestTypePipe
+	RequestTypeAudit
+	RequestTypeStats
+	RequestTypeAdmin
+	RequestTypePolicyTest
+	RequestTypeExec
+	RequestTypeShell
+	RequestTypeProcess
+	RequestTypeJob
+	RequestTypeEdit
 )
 
+var requestTypeNames = map[RequestType]string{
+	RequestTypeClipboard:  "clipboard",
+	RequestTypeRun:        "run",
+	RequestTypePipe:       "pipe",
+	RequestTypeAudit:      "audit",
+	RequestTypeStats:      "stats",
+	RequestTypeAdmin:      "admin",
+	RequestTypePolicyTest: "policy",
+	RequestTypeExec:       "exec",
+	RequestTypeShell:      "shell",
+	RequestTypeProcess:    "process",
+	RequestTypeJob:        "job",
+	RequestTypeEdit:       "edit",
+}
+
+// IsProcess reports whether requests of type t start a program on the server.
+func (t RequestType) IsProcess() bool {
+	return t == RequestTypeRun || t == RequestTypePipe || t == RequestTypeExec || t == RequestTypeShell || t == RequestTypeEdit
+}
+
+func (t RequestType) String() string {
+	if name, ok := requestTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+func ParseRequestType(name string) (RequestType, error) {
+	for t, n := range requestTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown request type %q", name)
+}
+
 type Request struct {
 	Type       RequestType `json:"type"`
 	Data       string      `json:"data,omitempty"`
@@ -15,4 +66,110 @@ type Request struct {
 	WorkingDir string      `json:"workingDir,omitempty"`
 	Password   string      `json:"password,omitempty"`
 	Stdin      string      `json:"stdin,omitempty"`
+	// StreamStdin asks for stdin to be streamed as frames instead of sent in Stdin.
+	StreamStdin bool         `json:"streamStdin,omitempty"`
+	AuditFilter *AuditFilter `json:"auditFilter,omitempty"`
+	PublicKey   []byte       `json:"publicKey,omitempty"`
+	// ApprovalTimeout is how long the client is willing to wait for the request to be approved.
+	ApprovalTimeout Duration `json:"approvalTimeout,omitempty"`
+	// Terminal is the size of the client's terminal for shell requests.
+	Terminal *TerminalSize `json:"terminal,omitempty"`
+	// Env sets environment variables for the program. They are merged into the server user's
+	// environment unless CleanEnv is set.
+	Env      map[string]string `json:"env,omitempty"`
+	CleanEnv bool              `json:"cleanEnv,omitempty"`
+	// Timeout is how long the program may run. The server's maxRuntime limit applies if it is shorter.
+	Timeout Duration `json:"timeout,omitempty"`
+	// Detach asks for a run request's program to be started as a job whose output and exit status
+	// the server keeps. The response carries the job's JobInfo.
+	Detach bool `json:"detach,omitempty"`
+	// Verb is the shell verb a run request applies to its file, such as print or runas. Empty uses
+	// the file's default action.
+	Verb string `json:"verb,omitempty"`
+	// Wait asks for a run request to be answered only once its program exits, with its ProcessInfo.
+	Wait bool `json:"wait,omitempty"`
+	// FileName and File are the name and content of the file an edit request opens in the editor.
+	FileName string `json:"fileName,omitempty"`
+	File     []byte `json:"file,omitempty"`
+}
+
+type TerminalSize struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+// Challenge is sent by the server in reply to a request carrying a public key. The client answers
+// with a Proof signing it with the matching private key.
+type Challenge struct {
+	Challenge []byte `json:"challenge"`
+}
+
+type Proof struct {
+	Signature []byte `json:"signature"`
+}
+
+// Admin verbs are sent in Request.Data of an admin request.
+const (
+	AdminVerbBans   = "bans"
+	AdminVerbPause  = "pause"
+	AdminVerbResume = "resume"
+	AdminVerbReload = "reload"
+	AdminVerbStatus = "status"
+	AdminVerbKick   = "kick"
+)
+
+// Process verbs are sent in Request.Data of a process request, with the process ID in Request.Args.
+const (
+	ProcessVerbList = "list"
+	ProcessVerbKill = "kill"
+	ProcessVerbWait = "wait"
+)
+
+// Job verbs are sent in Request.Data of a job request, with the job ID in Request.Args.
+const (
+	JobVerbList   = "list"
+	JobVerbStatus = "status"
+	JobVerbLogs   = "logs"
+	JobVerbWait   = "wait"
+)
+
+type ServerStatus struct {
+	Paused      bool            `json:"paused"`
+	Started     time.Time       `json:"started"`
+	Connections []ActiveRequest `json:"connections"`
+	Processes   []ProcessInfo   `json:"processes"`
+}
+
+type ResponseStatus string
+
+const (
+	StatusOK           ResponseStatus = "ok"
+	StatusError        ResponseStatus = "error"
+	StatusUnauthorized ResponseStatus = "unauthorized"
+	StatusRateLimited  ResponseStatus = "rate_limited"
+	StatusLockedOut    ResponseStatus = "locked_out"
+	StatusPaused       ResponseStatus = "paused"
+	StatusForbidden    ResponseStatus = "forbidden"
+	StatusDenied       ResponseStatus = "denied"
+	StatusTimedOut     ResponseStatus = "timed_out"
+)
+
+type Response struct {
+	Status     ResponseStatus  `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Credential string          `json:"credential,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// ResponseError is returned by the client when the server answers with a non-OK status.
+type ResponseError struct {
+	Status  ResponseStatus
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server responded with status %s", e.Status)
+	}
+	return fmt.Sprintf("server responded with status %s: %s", e.Status, e.Message)
 }
@@ -0,0 +1,218 @@
+package clipd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SandboxConfig confines the working directories of requests starting programs, and the programs,
+// targets and arguments that look like paths, to Roots. An empty list disables the sandbox.
+type SandboxConfig struct {
+	Roots []string `json:"roots,omitempty"`
+
+	roots []string
+}
+
+// SandboxError is returned for paths outside the sandbox roots.
+type SandboxError struct {
+	Path     string
+	Resolved string
+	Roots    []string
+}
+
+func (e *SandboxError) Error() string {
+	if e.Resolved == "" {
+		return fmt.Sprintf("path %q is not allowed: it cannot be resolved to an absolute path", e.Path)
+	}
+	return fmt.Sprintf("path %q resolves to %s, which is outside the allowed roots (%s)", e.Path, e.Resolved, strings.Join(e.Roots, ", "))
+}
+
+func (s *SandboxConfig) compile() error {
+	s.roots = s.roots[:0]
+	for _, root := range s.Roots {
+		normalized, err := NormalizeWindowsPath(root, "")
+		if err != nil {
+			return fmt.Errorf("invalid sandbox root %q: %w", root, err)
+		}
+		s.roots = append(s.roots, normalized)
+	}
+	return nil
+}
+
+func (s *SandboxConfig) Enabled() bool {
+	return len(s.roots) > 0
+}
+
+// Check confines workingDir, and target and the arguments in args if they look like paths, to the
+// sandbox roots. Target is the program or file a request starts; bare program names found on the PATH,
+// URLs and shell URIs like shell:startup are not confined. It returns the normalized working directory
+// to launch in, which is the first root when workingDir is empty.
+func (s *SandboxConfig) Check(target, workingDir string, args []string) (string, error) {
+	if !s.Enabled() {
+		return workingDir, nil
+	}
+	if workingDir == "" {
+		workingDir = s.roots[0]
+	}
+	dir, err := s.confine(workingDir, "")
+	if err != nil {
+		return "", err
+	}
+	if !IsURL(target) && looksLikePath(target) {
+		if _, err := s.confine(target, dir); err != nil {
+			return "", err
+		}
+	}
+	for _, arg := range args {
+		path, ok := pathArgument(arg)
+		if !ok {
+			continue
+		}
+		if _, err := s.confine(path, dir); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func (s *SandboxConfig) confine(path, base string) (string, error) {
+	resolved, err := NormalizeWindowsPath(path, base)
+	if err != nil {
+		return "", &SandboxError{Path: path, Roots: s.roots}
+	}
+	for _, root := range s.roots {
+		if hasPathPrefix(resolved, root) {
+			return resolved, nil
+		}
+	}
+	return "", &SandboxError{Path: path, Resolved: resolved, Roots: s.roots}
+}
+
+// pathArgument returns the part of arg that names a path outside the working directory, if any:
+// absolute, root-relative and drive-relative paths, paths with backslashes, and paths containing "..".
+// The value of an option like --out=C:\file is checked as well. Only a single character after a /, as
+// in /c or /o:C:\file, is taken as a switch, whose value is checked in turn.
+func pathArgument(arg string) (string, bool) {
+	if isSwitch(arg) {
+		if _, value, ok := strings.Cut(arg, ":"); ok && looksLikePath(value) {
+			return value, true
+		}
+		return arg, false
+	}
+	if _, value, ok := strings.Cut(arg, "="); ok && looksLikePath(value) {
+		return value, true
+	}
+	return arg, looksLikePath(arg)
+}
+
+func isSwitch(arg string) bool {
+	return len(arg) >= 2 && arg[0] == '/' && arg[1] != '/' && arg[1] != '\\' && (len(arg) == 2 || arg[2] == ':')
+}
+
+func looksLikePath(s string) bool {
+	if hasDriveLetter(s) || strings.Contains(s, `\`) || strings.HasPrefix(s, "/") {
+		return true
+	}
+	for _, part := range strings.Split(s, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDriveLetter(s string) bool {
+	return len(s) >= 2 && s[1] == ':' && (s[0] >= 'a' && s[0] <= 'z' || s[0] >= 'A' && s[0] <= 'Z')
+}
+
+// NormalizeWindowsPath resolves path to a clean absolute Windows path the way Win32 would, without
+// touching the file system. Relative, root-relative (\dir) and drive-relative (C:dir) paths are
+// resolved against base, which must then be absolute; drive-relative paths must be on base's drive.
+// Forward slashes become backslashes, . and .. are resolved (.. never climbs above the drive or share
+// root), trailing dots and spaces are trimmed from each component as Windows does, and the \\?\ and
+// \\?\UNC\ prefixes are removed. Device paths (\\.\), and \\?\ paths naming neither a drive nor a UNC
+// share, are rejected.
+func NormalizeWindowsPath(path, base string) (string, error) {
+	path = strings.ReplaceAll(path, "/", `\`)
+	if path == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	switch {
+	case strings.HasPrefix(strings.ToUpper(path), `\\?\UNC\`):
+		path = `\\` + path[len(`\\?\UNC\`):]
+	case strings.HasPrefix(path, `\\?\`):
+		path = path[len(`\\?\`):]
+		if !hasDriveLetter(path) || !strings.HasPrefix(path[2:], `\`) {
+			return "", fmt.Errorf(`\\?\ paths must name a drive or UNC share`)
+		}
+	case strings.HasPrefix(path, `\\.\`):
+		return "", fmt.Errorf("device paths are not supported")
+	}
+	root, rest, err := splitWindowsRoot(path)
+	if err != nil {
+		return "", err
+	}
+	if root == "" || (hasDriveLetter(root) && !strings.HasPrefix(rest, `\`)) {
+		if base == "" {
+			return "", fmt.Errorf("relative path %q needs a base directory", path)
+		}
+		normalizedBase, err := NormalizeWindowsPath(base, "")
+		if err != nil {
+			return "", fmt.Errorf("invalid base directory: %w", err)
+		}
+		baseRoot, baseRest, _ := splitWindowsRoot(normalizedBase)
+		switch {
+		case root != "" && !strings.EqualFold(root, baseRoot):
+			return "", fmt.Errorf("drive-relative path %q is not on the drive of %s", path, normalizedBase)
+		case root == "" && strings.HasPrefix(rest, `\`):
+			// Root-relative: the drive or share of base.
+		default:
+			rest = baseRest + `\` + rest
+		}
+		root = baseRoot
+	}
+	var parts []string
+	for _, part := range strings.Split(rest, `\`) {
+		if trimmed := strings.TrimRight(part, " "); trimmed == "." || trimmed == ".." {
+			part = trimmed
+		} else {
+			part = strings.TrimRight(part, ". ")
+		}
+		switch part {
+		case "", ".":
+		case "..":
+			if len(parts) > 0 {
+				parts = parts[:len(parts)-1]
+			}
+		default:
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 && !strings.HasPrefix(root, `\\`) {
+		return root + `\`, nil
+	}
+	if len(parts) == 0 {
+		return root, nil
+	}
+	return root + `\` + strings.Join(parts, `\`), nil
+}
+
+// splitWindowsRoot splits a backslash-separated path into its drive (C:) or UNC share (\\server\share)
+// and the remainder. The root is empty for relative and root-relative paths.
+func splitWindowsRoot(path string) (string, string, error) {
+	if hasDriveLetter(path) {
+		return strings.ToUpper(path[:1]) + ":", path[2:], nil
+	}
+	if strings.HasPrefix(path, `\\`) {
+		parts := strings.SplitN(path[2:], `\`, 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return "", "", fmt.Errorf("UNC path %q must name a server and share", path)
+		}
+		rest := ""
+		if len(parts) == 3 {
+			rest = `\` + parts[2]
+		}
+		return `\\` + parts[0] + `\` + parts[1], rest, nil
+	}
+	return "", path, nil
+}
@@ -0,0 +1,127 @@
+package clipd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeWindowsPath(t *testing.T) {
+	tests := []struct {
+		path, base string
+		want       string
+		wantErr    bool
+	}{
+		{path: `C:\Users\me`, want: `C:\Users\me`},
+		{path: `c:/Users/me/`, want: `C:\Users\me`},
+		{path: `C:\Users\me\..\..\..\Windows`, want: `C:\Windows`},
+		{path: `C:\a\.\b\.\`, want: `C:\a\b`},
+		{path: `C:\`, want: `C:\`},
+		{path: `C:\..`, want: `C:\`},
+		{path: `C:\a\b.. \c`, want: `C:\a\b\c`},
+		{path: `C:\a\b\.. \c`, want: `C:\a\c`},
+		{path: `C:\a\...\b`, want: `C:\a\b`},
+		{path: `C:\a\name. . `, want: `C:\a\name`},
+		{path: `docs\file.txt`, base: `C:\work`, want: `C:\work\docs\file.txt`},
+		{path: `..\..\secret`, base: `C:\work\project`, want: `C:\secret`},
+		{path: `\Windows`, base: `D:\work`, want: `D:\Windows`},
+		{path: `D:notes.txt`, base: `d:\work`, want: `D:\work\notes.txt`},
+		{path: `D:`, base: `D:\work`, want: `D:\work`},
+		{path: `C:notes.txt`, base: `D:\work`, wantErr: true},
+		{path: `relative`, wantErr: true},
+		{path: `\rooted`, wantErr: true},
+		{path: `C:relative`, wantErr: true},
+		{path: `\\server\share\dir\..\..\..\x`, want: `\\server\share\x`},
+		{path: `//server/share/dir`, want: `\\server\share\dir`},
+		{path: `\\server\share`, want: `\\server\share`},
+		{path: `\Other`, base: `\\server\share\dir`, want: `\\server\share\Other`},
+		{path: `\\server`, wantErr: true},
+		{path: `\\server\`, wantErr: true},
+		{path: `\\?\C:\a\..\b`, want: `C:\b`},
+		{path: `\\?\UNC\server\share\a`, want: `\\server\share\a`},
+		{path: `\\.\PhysicalDrive0`, wantErr: true},
+		{path: `\\?\GLOBALROOT\Device\HarddiskVolume1\Windows`, base: `C:\work`, wantErr: true},
+		{path: `\\?\Volume{b75e2c83-0000-0000-0000-602f00000000}\x`, base: `C:\work`, wantErr: true},
+		{path: `\\?\C:`, base: `C:\work`, wantErr: true},
+		{path: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeWindowsPath(tt.path, tt.base)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NormalizeWindowsPath(%q, %q) = %q, want error", tt.path, tt.base, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeWindowsPath(%q, %q) = %q, %v, want %q", tt.path, tt.base, got, err, tt.want)
+		}
+	}
+}
+
+func TestSandboxCheck(t *testing.T) {
+	sandbox := SandboxConfig{Roots: []string{`C:\work`, `\\nas\builds`}}
+	if err := sandbox.compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target     string
+		workingDir string
+		args       []string
+		wantDir    string
+		wantErr    bool
+	}{
+		{workingDir: ``, wantDir: `C:\work`},
+		{workingDir: `c:\WORK\project`, wantDir: `C:\WORK\project`},
+		{workingDir: `C:\work\..\Windows`, wantErr: true},
+		{workingDir: `C:\workshop`, wantErr: true},
+		{workingDir: `\\nas\builds\42`, wantDir: `\\nas\builds\42`},
+		{workingDir: `\\nas\other`, wantErr: true},
+		{workingDir: `project`, wantErr: true},
+		{workingDir: `C:\work`, args: []string{`/c`, `notes.txt`, `sub/file`}, wantDir: `C:\work`},
+		{workingDir: `C:\work`, args: []string{`..\secret.txt`}, wantErr: true},
+		{workingDir: `C:\work\a`, args: []string{`../b/file`}, wantDir: `C:\work\a`},
+		{workingDir: `C:\work`, args: []string{`../../etc`}, wantErr: true},
+		{workingDir: `C:\work`, args: []string{`C:\Windows\win.ini`}, wantErr: true},
+		{workingDir: `C:\work`, args: []string{`C:secret`}, wantDir: `C:\work`},
+		{workingDir: `C:\work`, args: []string{`D:secret`}, wantErr: true},
+		{workingDir: `C:\work`, args: []string{`\Windows`}, wantErr: true},
+		{workingDir: `C:\work`, args: []string{`--out=C:\Windows\x`}, wantErr: true},
+		{workingDir: `C:\work`, args: []string{`--out=C:\work\x`}, wantDir: `C:\work`},
+		{workingDir: `C:\work`, args: []string{`\\nas\builds\artifact.zip`}, wantDir: `C:\work`},
+		{workingDir: `C:\work`, args: []string{`/Windows/win.ini`}, wantErr: true},
+		{workingDir: `C:\work`, args: []string{`/work/notes.txt`}, wantDir: `C:\work`},
+		{workingDir: `C:\work`, args: []string{`/quiet`}, wantErr: true},
+		{workingDir: `C:\work`, args: []string{`/o:C:\Windows\x`}, wantErr: true},
+		{workingDir: `C:\work`, args: []string{`/o:out.txt`, `/?`}, wantDir: `C:\work`},
+		{workingDir: `C:\work`, args: []string{`\\?\GLOBALROOT\Device\HarddiskVolume1\Windows`}, wantErr: true},
+		{target: `notepad.exe`, workingDir: `C:\work`, wantDir: `C:\work`},
+		{target: `C:\work\tools\build.exe`, workingDir: `C:\work`, wantDir: `C:\work`},
+		{target: `tools\build.exe`, workingDir: `C:\work`, wantDir: `C:\work`},
+		{target: `C:\outside\tool.exe`, workingDir: `C:\work`, wantErr: true},
+		{target: `..\tool.exe`, workingDir: `C:\work`, wantErr: true},
+		{target: `\\evil\share\tool.exe`, workingDir: `C:\work`, wantErr: true},
+		{target: `https://example.com/a\b`, workingDir: `C:\work`, wantDir: `C:\work`},
+		{target: `shell:startup`, workingDir: `C:\work`, wantDir: `C:\work`},
+	}
+	for _, tt := range tests {
+		dir, err := sandbox.Check(tt.target, tt.workingDir, tt.args)
+		if tt.wantErr {
+			var sandboxErr *SandboxError
+			if !errors.As(err, &sandboxErr) {
+				t.Errorf("Check(%q, %q, %q) = %q, %v, want a SandboxError", tt.target, tt.workingDir, tt.args, dir, err)
+			}
+			continue
+		}
+		if err != nil || dir != tt.wantDir {
+			t.Errorf("Check(%q, %q, %q) = %q, %v, want %q", tt.target, tt.workingDir, tt.args, dir, err, tt.wantDir)
+		}
+	}
+}
+
+func TestSandboxDisabled(t *testing.T) {
+	var sandbox SandboxConfig
+	dir, err := sandbox.Check(`C:\Windows\notepad.exe`, `anything`, []string{`C:\Windows`})
+	if err != nil || dir != `anything` {
+		t.Errorf("Check on disabled sandbox = %q, %v", dir, err)
+	}
+}
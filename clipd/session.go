@@ -0,0 +1,501 @@
+package clipd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const streamChunkSize = 32 * 1024
+
+// StdinWindow is how many bytes of stdin a client may have in flight before the server reports that
+// the process has consumed them. It bounds what the server buffers for a slow process.
+const StdinWindow = 256 * 1024
+
+// Process is a program started for a streaming request. Stdin, Stdout and Stderr return nil when that
+// stream is not connected to the client.
+type Process interface {
+	PID() int
+	Stdin() io.WriteCloser
+	Stdout() io.Reader
+	Stderr() io.Reader
+	// Wait blocks until the process exits and returns its exit code.
+	Wait() (int, error)
+	Kill() error
+}
+
+// ServeOptions controls how the server runs the process of a streaming request.
+type ServeOptions struct {
+	// KillGrace is how long an interrupted process has to exit before its process tree is terminated.
+	KillGrace time.Duration
+	// MaxOutput is how many bytes of stdout and stderr the process may write before it is killed.
+	// Zero is unlimited.
+	MaxOutput int64
+}
+
+// ExitStatus is how a program ended. Reason is empty when it exited on its own.
+type ExitStatus struct {
+	Code   int
+	Reason string
+}
+
+func (s ExitStatus) String() string {
+	switch s.Reason {
+	case "":
+		return fmt.Sprintf("exited with code %d", s.Code)
+	case ExitInterrupted:
+		return fmt.Sprintf("was interrupted and exited with code %d", s.Code)
+	case ExitKilled:
+		return "was killed"
+	case ExitTimedOut:
+		return "was killed for running too long"
+	case ExitOutputLimit:
+		return "was killed for writing too much output"
+	case ExitMemoryLimit:
+		return "was killed for using too much memory"
+	default:
+		return fmt.Sprintf("ended (%s) with code %d", s.Reason, s.Code)
+	}
+}
+
+// ServeProcess connects proc to the client until the process exits and its output is drained, then
+// sends its exit status. The process is killed if the client goes away first.
+func ServeProcess(stream *Stream, proc Process, options ServeOptions) (ExitStatus, error) {
+	done := make(chan struct{})
+	defer close(done)
+	input := newInputQueue()
+	defer input.close()
+	if stdin := proc.Stdin(); stdin != nil {
+		go writeInput(stream, input, stdin)
+	} else {
+		stream.Send(Frame{Type: FrameEOF})
+	}
+	stop := newStopper(proc, options.KillGrace)
+	go func() {
+		receiveFrames(stream, input, proc, stop.signal)
+		input.close()
+		select {
+		case <-done:
+		default:
+			proc.Kill()
+		}
+	}()
+	var wg sync.WaitGroup
+	var sendErr error
+	var sendOnce sync.Once
+	var sent atomic.Int64
+	pump := func(r io.Reader, frameType string) {
+		defer wg.Done()
+		buf := make([]byte, streamChunkSize)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				data := buf[:n]
+				exceeded := false
+				if options.MaxOutput > 0 {
+					if over := sent.Add(int64(n)) - options.MaxOutput; over > 0 {
+						data = data[:max(0, int64(n)-over)]
+						exceeded = true
+					}
+				}
+				if len(data) > 0 {
+					if err := stream.Send(Frame{Type: frameType, Data: data}); err != nil {
+						sendOnce.Do(func() { sendErr = err })
+						proc.Kill()
+						io.Copy(io.Discard, r)
+						return
+					}
+				}
+				if exceeded {
+					stop.kill(ExitOutputLimit)
+					io.Copy(io.Discard, r)
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	for _, output := range []struct {
+		r         io.Reader
+		frameType string
+	}{{proc.Stdout(), FrameStdout}, {proc.Stderr(), FrameStderr}} {
+		if output.r != nil {
+			wg.Add(1)
+			go pump(output.r, output.frameType)
+		}
+	}
+	wg.Wait()
+	code, err := proc.Wait()
+	status := ExitStatus{Code: code, Reason: stop.finish()}
+	if err != nil {
+		return status, fmt.Errorf("failed to wait for process %d: %w", proc.PID(), err)
+	}
+	if sendErr != nil {
+		return status, fmt.Errorf("client went away: %w", sendErr)
+	}
+	if err := stream.Send(Frame{Type: FrameExit, Code: code, Reason: status.Reason}); err != nil {
+		return status, fmt.Errorf("failed to send exit code: %w", err)
+	}
+	return status, nil
+}
+
+// ServeInput writes the stdin the client streams to proc until the client closes it, then closes the
+// process's stdin and acknowledges with FrameEOF. It also returns if the process stops reading, telling
+// the client to stop sending. The process keeps running afterwards unless the client signaled it; then
+// ServeInput waits for it to end, sends its exit status and returns it.
+func ServeInput(stream *Stream, proc Process, options ServeOptions) (*ExitStatus, error) {
+	input := newInputQueue()
+	stop := newStopper(proc, options.KillGrace)
+	signaled := make(chan struct{})
+	var signalOnce sync.Once
+	written := make(chan error, 1)
+	go func() {
+		written <- writeInput(stream, input, proc.Stdin())
+	}()
+	go func() {
+		receiveFrames(stream, input, proc, func(signal string) {
+			// A stopped program needs no more input.
+			signalOnce.Do(func() { close(signaled) })
+			input.close()
+			stop.signal(signal)
+		})
+		input.close()
+	}()
+	err := <-written
+	select {
+	case <-signaled:
+	default:
+		return nil, err
+	}
+	code, err := proc.Wait()
+	status := &ExitStatus{Code: code, Reason: stop.finish()}
+	if err != nil {
+		return status, fmt.Errorf("failed to wait for process %d: %w", proc.PID(), err)
+	}
+	if err := stream.Send(Frame{Type: FrameExit, Code: code, Reason: status.Reason}); err != nil {
+		return status, fmt.Errorf("failed to send exit code: %w", err)
+	}
+	return status, nil
+}
+
+// stopper stops a process when the client signals it and remembers why the process ended.
+type stopper struct {
+	proc     Process
+	grace    time.Duration
+	mu       sync.Mutex
+	reason   string
+	timer    *time.Timer
+	finished bool
+}
+
+func newStopper(proc Process, grace time.Duration) *stopper {
+	return &stopper{proc: proc, grace: grace}
+}
+
+// signal handles a FrameSignal. An interrupt falls back to terminating the process tree when the
+// process cannot be sent Ctrl-C.
+func (s *stopper) signal(signal string) {
+	switch signal {
+	case SignalInterrupt:
+		if interrupter, ok := s.proc.(Interrupter); ok && interrupter.Interrupt() == nil {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.reason == "" {
+				s.reason = ExitInterrupted
+			}
+			if s.timer == nil && !s.finished {
+				s.timer = time.AfterFunc(s.grace, func() { s.kill(ExitKilled) })
+			}
+			return
+		}
+		s.kill(ExitKilled)
+	case SignalTerminate:
+		s.kill(ExitKilled)
+	}
+}
+
+// kill terminates the process for reason. The first reason is kept, except that a kill replaces an
+// interrupt.
+func (s *stopper) kill(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return
+	}
+	if s.reason == "" || s.reason == ExitInterrupted {
+		s.reason = reason
+	}
+	s.proc.Kill()
+}
+
+// finish is called once the process has exited. It cancels a pending kill and returns why the process
+// ended, preferring a limit the process itself reports.
+func (s *stopper) finish() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if reporter, ok := s.proc.(LimitReporter); ok {
+		if limit := reporter.ExceededLimit(); limit != "" {
+			return limit
+		}
+	}
+	return s.reason
+}
+
+// receiveFrames handles the frames a client sends during a streaming request until the connection
+// fails or is closed, passing signals to signal.
+func receiveFrames(stream *Stream, input *inputQueue, proc Process, signal func(string)) error {
+	for {
+		frame, err := stream.Receive()
+		if err != nil {
+			return err
+		}
+		switch frame.Type {
+		case FrameStdin:
+			if err := input.push(frame.Data); err != nil {
+				return err
+			}
+		case FrameEOF:
+			input.close()
+		case FrameResize:
+			if resizer, ok := proc.(Resizer); ok && frame.Cols > 0 && frame.Rows > 0 {
+				resizer.Resize(TerminalSize{Cols: frame.Cols, Rows: frame.Rows})
+			}
+		case FrameSignal:
+			signal(frame.Signal)
+		}
+	}
+}
+
+// writeInput writes queued stdin to w, granting the client window for each chunk written. When the
+// queue is closed or the process stops reading, it closes w and sends FrameEOF.
+func writeInput(stream *Stream, input *inputQueue, w io.WriteCloser) error {
+	defer stream.Send(Frame{Type: FrameEOF})
+	defer w.Close()
+	for {
+		chunk, ok := input.pop()
+		if !ok {
+			return nil
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return fmt.Errorf("process stopped reading stdin: %w", err)
+		}
+		if err := stream.Send(Frame{Type: FrameWindow, Size: len(chunk)}); err != nil {
+			return err
+		}
+	}
+}
+
+// inputQueue holds stdin received from the client until it is written to the process.
+type inputQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	chunks  [][]byte
+	pending int
+	closed  bool
+}
+
+func newInputQueue() *inputQueue {
+	q := &inputQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *inputQueue) push(data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	if q.pending+len(data) > StdinWindow {
+		return fmt.Errorf("client sent more stdin than its window allows")
+	}
+	q.chunks = append(q.chunks, data)
+	q.pending += len(data)
+	q.cond.Signal()
+	return nil
+}
+
+// pop waits for the next chunk. It returns false once the queue is closed and empty.
+func (q *inputQueue) pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.chunks) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.chunks) == 0 {
+		return nil, false
+	}
+	chunk := q.chunks[0]
+	q.chunks = q.chunks[1:]
+	q.pending -= len(chunk)
+	return chunk, true
+}
+
+func (q *inputQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// inputWindow tracks how much stdin the client may send before the server catches up.
+type inputWindow struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	available int
+	closed    bool
+}
+
+func newInputWindow() *inputWindow {
+	w := &inputWindow{available: StdinWindow}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// acquire waits until some window is available and takes up to max bytes of it. It returns 0 once
+// the server has closed stdin.
+func (w *inputWindow) acquire(max int) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.available == 0 && !w.closed {
+		w.cond.Wait()
+	}
+	if w.closed {
+		return 0
+	}
+	n := min(max, w.available)
+	w.available -= n
+	return n
+}
+
+func (w *inputWindow) grant(n int) {
+	w.mu.Lock()
+	w.available += n
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+func (w *inputWindow) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// sendInput streams r to the server as stdin frames, never sending more than the window allows, and
+// sends FrameEOF when r is exhausted.
+func sendInput(stream *Stream, r io.Reader, window *inputWindow) {
+	buf := make([]byte, streamChunkSize)
+	for {
+		n := window.acquire(len(buf))
+		if n == 0 {
+			return
+		}
+		read, err := r.Read(buf[:n])
+		window.grant(n - read)
+		n = read
+		if n > 0 {
+			if stream.Send(Frame{Type: FrameStdin, Data: buf[:n]}) != nil {
+				return
+			}
+		}
+		if err != nil {
+			stream.Send(Frame{Type: FrameEOF})
+			return
+		}
+	}
+}
+
+// SessionIO connects a streaming request to local streams. Any of them may be nil: without Stdin the
+// program gets no input, and output without a writer is discarded. Resize delivers terminal size
+// changes for shell requests, and Signals SignalInterrupt or SignalTerminate to stop the program.
+type SessionIO struct {
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Resize  <-chan TerminalSize
+	Signals <-chan string
+}
+
+// runSession forwards local input to the server and writes the output frames of a streaming request
+// until the server sends a frame of type until, which it returns. Once a signal has been sent it waits
+// for FrameExit instead, which the server sends after stopping the program.
+func runSession(stream *Stream, sio SessionIO, until string) (Frame, error) {
+	window := newInputWindow()
+	defer window.close()
+	if sio.Stdin != nil {
+		go sendInput(stream, sio.Stdin, window)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	var signaled atomic.Bool
+	if sio.Resize != nil || sio.Signals != nil {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case size := <-sio.Resize:
+					stream.Send(Frame{Type: FrameResize, Cols: size.Cols, Rows: size.Rows})
+				case signal := <-sio.Signals:
+					signaled.Store(true)
+					stream.Send(Frame{Type: FrameSignal, Signal: signal})
+				}
+			}
+		}()
+	}
+	// reached is set when until arrived after a signal; the server may still finish without FrameExit
+	// if the signal came too late.
+	var reached *Frame
+	stdout, stderr := sio.Stdout, sio.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	for {
+		frame, err := stream.Receive()
+		if err != nil {
+			if reached != nil {
+				return *reached, nil
+			}
+			if errors.Is(err, io.EOF) {
+				return frame, fmt.Errorf("connection closed before the program exited")
+			}
+			return frame, fmt.Errorf("error reading from server: %w", err)
+		}
+		if frame.Type == FrameExit {
+			return frame, nil
+		}
+		if frame.Type == until {
+			if !signaled.Load() {
+				return frame, nil
+			}
+			reached = &frame
+		}
+		switch frame.Type {
+		case FrameStdout:
+			if _, err := stdout.Write(frame.Data); err != nil {
+				return frame, err
+			}
+		case FrameStderr:
+			if _, err := stderr.Write(frame.Data); err != nil {
+				return frame, err
+			}
+		case FrameWindow:
+			window.grant(frame.Size)
+		case FrameEOF:
+			window.close()
+		}
+	}
+}
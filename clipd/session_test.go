@@ -0,0 +1,242 @@
+package clipd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProcess echoes its input to stdout in upper case, records resizes and interrupts, and exits
+// with code 3 once its input is closed.
+type fakeProcess struct {
+	stdinR     *io.PipeReader
+	stdinW     *io.PipeWriter
+	stdoutR    *io.PipeReader
+	stdoutW    *io.PipeWriter
+	exited     chan struct{}
+	mu         sync.Mutex
+	sizes      []TerminalSize
+	interrupts int
+	killed     bool
+}
+
+func newFakeProcess() *fakeProcess {
+	p := &fakeProcess{exited: make(chan struct{})}
+	p.stdinR, p.stdinW = io.Pipe()
+	p.stdoutR, p.stdoutW = io.Pipe()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := p.stdinR.Read(buf)
+			if n > 0 {
+				p.stdoutW.Write(bytes.ToUpper(buf[:n]))
+			}
+			if err != nil {
+				break
+			}
+		}
+		p.stdoutW.Close()
+		close(p.exited)
+	}()
+	return p
+}
+
+func (p *fakeProcess) PID() int              { return 42 }
+func (p *fakeProcess) Stdin() io.WriteCloser { return p.stdinW }
+func (p *fakeProcess) Stdout() io.Reader     { return p.stdoutR }
+func (p *fakeProcess) Stderr() io.Reader     { return nil }
+
+func (p *fakeProcess) Wait() (int, error) {
+	<-p.exited
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.killed {
+		return 1, nil
+	}
+	return 3, nil
+}
+
+func (p *fakeProcess) Kill() error {
+	p.mu.Lock()
+	p.killed = true
+	p.mu.Unlock()
+	p.stdinR.Close()
+	return nil
+}
+
+func (p *fakeProcess) Resize(size TerminalSize) error {
+	p.mu.Lock()
+	p.sizes = append(p.sizes, size)
+	p.mu.Unlock()
+	return nil
+}
+
+// Interrupt records the Ctrl-C but, like a program that ignores it, keeps running.
+func (p *fakeProcess) Interrupt() error {
+	p.mu.Lock()
+	p.interrupts++
+	p.mu.Unlock()
+	return nil
+}
+
+type fakeLauncher struct {
+	specs chan LaunchSpec
+	proc  *fakeProcess
+}
+
+func (l *fakeLauncher) Launch(spec LaunchSpec) (Process, error) {
+	l.specs <- spec
+	return l.proc, nil
+}
+
+// serveOne accepts a single streaming request and serves it with launcher the way the server does.
+func serveOne(t *testing.T, launcher Launcher) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		decoder := json.NewDecoder(conn)
+		var req Request
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+		proc, err := launcher.Launch(req.LaunchSpec())
+		if err != nil {
+			json.NewEncoder(conn).Encode(Response{Status: StatusError, Error: err.Error()})
+			return
+		}
+		json.NewEncoder(conn).Encode(Response{Status: StatusOK})
+		ServeProcess(NewStream(conn, decoder), proc, ServeOptions{KillGrace: 50 * time.Millisecond})
+	}()
+	return ln.Addr().String()
+}
+
+func TestShellSession(t *testing.T) {
+	proc := newFakeProcess()
+	launcher := &fakeLauncher{specs: make(chan LaunchSpec, 1), proc: proc}
+	address := serveOne(t, launcher)
+	resize := make(chan TerminalSize, 1)
+	stdinR, stdinW := io.Pipe()
+	var stdout bytes.Buffer
+	go func() {
+		stdinW.Write([]byte("dir\r"))
+		resize <- TerminalSize{Cols: 120, Rows: 40}
+		// Give the resize time to arrive before the session ends.
+		time.Sleep(50 * time.Millisecond)
+		stdinW.Close()
+	}()
+	status, err := SendShellRequest(address, "cmd.exe", []string{"/k"}, `C:\work`, TerminalSize{Cols: 100, Rows: 30}, RunOptions{}, Auth{}, SessionIO{Stdin: stdinR, Stdout: &stdout, Resize: resize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Code != 3 || status.Reason != "" {
+		t.Errorf("exit status = %+v, want code 3", status)
+	}
+	if got := stdout.String(); got != "DIR\r" {
+		t.Errorf("stdout = %q, want %q", got, "DIR\r")
+	}
+	spec := <-launcher.specs
+	if spec.Program != "cmd.exe" || strings.Join(spec.Args, " ") != "/k" || spec.WorkingDir != `C:\work` {
+		t.Errorf("launched %+v", spec)
+	}
+	if spec.Terminal == nil || *spec.Terminal != (TerminalSize{Cols: 100, Rows: 30}) {
+		t.Errorf("terminal = %+v, want 100x30", spec.Terminal)
+	}
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if len(proc.sizes) != 1 || proc.sizes[0] != (TerminalSize{Cols: 120, Rows: 40}) {
+		t.Errorf("resizes = %+v, want one 120x40", proc.sizes)
+	}
+}
+
+func TestSessionKilledWhenClientLeaves(t *testing.T) {
+	proc := newFakeProcess()
+	launcher := &fakeLauncher{specs: make(chan LaunchSpec, 1), proc: proc}
+	address := serveOne(t, launcher)
+	conn, stream, err := openStream(address, Request{Type: RequestTypeShell, Data: "cmd.exe"}, Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(Frame{Type: FrameStdin, Data: []byte("x")})
+	conn.Close()
+	select {
+	case <-proc.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process still running after the client disconnected")
+	}
+	if code, _ := proc.Wait(); code != 1 {
+		t.Errorf("exit code = %d, want the killed code 1", code)
+	}
+}
+
+func TestInterruptedProcessKilledAfterGrace(t *testing.T) {
+	proc := newFakeProcess()
+	launcher := &fakeLauncher{specs: make(chan LaunchSpec, 1), proc: proc}
+	address := serveOne(t, launcher)
+	stdinR, stdinW := io.Pipe()
+	defer stdinW.Close()
+	signals := make(chan string, 1)
+	signals <- SignalInterrupt
+	status, err := SendExecRequest(address, "ping.exe", []string{"-t", "localhost"}, "", RunOptions{}, Auth{}, SessionIO{Stdin: stdinR, Signals: signals})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != (ExitStatus{Code: 1, Reason: ExitKilled}) {
+		t.Errorf("exit status = %+v, want code 1 killed", status)
+	}
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if proc.interrupts != 1 {
+		t.Errorf("interrupts = %d, want 1", proc.interrupts)
+	}
+}
+
+func TestServeProcessOutputLimit(t *testing.T) {
+	proc := newFakeProcess()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	served := make(chan ExitStatus, 1)
+	go func() {
+		status, _ := ServeProcess(NewStream(serverConn, json.NewDecoder(serverConn)), proc, ServeOptions{MaxOutput: 4})
+		served <- status
+	}()
+
+	client := NewStream(clientConn, json.NewDecoder(clientConn))
+	go client.Send(Frame{Type: FrameStdin, Data: []byte("abcdef")})
+	var stdout bytes.Buffer
+	for {
+		frame, err := client.Receive()
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+		if frame.Type == FrameStdout {
+			stdout.Write(frame.Data)
+		}
+		if frame.Type == FrameExit {
+			if frame.Reason != ExitOutputLimit {
+				t.Errorf("exit reason = %q, want %q", frame.Reason, ExitOutputLimit)
+			}
+			break
+		}
+	}
+	if got := stdout.String(); got != "ABCD" {
+		t.Errorf("stdout = %q, want the first 4 bytes %q", got, "ABCD")
+	}
+	if status := <-served; status.Reason != ExitOutputLimit {
+		t.Errorf("ServeProcess status = %+v, want %s", status, ExitOutputLimit)
+	}
+}
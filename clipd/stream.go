@@ -0,0 +1,82 @@
+package clipd
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+const (
+	FrameStdout = "stdout"
+	FrameStderr = "stderr"
+	FrameExit   = "exit"
+	// FrameStdin carries input from the client. The client may only have StdinWindow bytes of it
+	// unacknowledged; the server returns window with FrameWindow as the process consumes the input.
+	FrameStdin  = "stdin"
+	FrameWindow = "window"
+	// FrameEOF closes stdin. From the client it means there is no more input; from the server, that the
+	// process no longer reads it.
+	FrameEOF = "eof"
+	// FrameResize tells the server the client's terminal changed size.
+	FrameResize = "resize"
+	// FrameSignal asks the server to stop the program; Signal is SignalInterrupt or SignalTerminate.
+	FrameSignal = "signal"
+	// FrameFile carries the content of the file of an edit request each time the editor saves it.
+	FrameFile = "file"
+)
+
+const (
+	// SignalInterrupt sends the program a console Ctrl-C. If it is still running after the server's
+	// kill grace period, its process tree is terminated.
+	SignalInterrupt = "interrupt"
+	// SignalTerminate terminates the program's process tree right away.
+	SignalTerminate = "terminate"
+)
+
+// Reasons a program ended early, reported in FrameExit. A program that exited on its own has none.
+const (
+	ExitInterrupted = "interrupted"
+	ExitKilled      = "killed"
+	ExitTimedOut    = "timed_out"
+	ExitOutputLimit = "output_limit"
+	ExitMemoryLimit = "memory_limit"
+)
+
+// Frame is one message of a streaming request. After the server accepts the request with an ok
+// response, both sides exchange frames until the server sends FrameExit.
+type Frame struct {
+	Type string `json:"type"`
+	Data []byte `json:"data,omitempty"`
+	Code int    `json:"code,omitempty"`
+	Size int    `json:"size,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+	// Signal is set on FrameSignal and Reason on FrameExit.
+	Signal string `json:"signal,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Stream sends and receives frames over a connection. Send may be called from several goroutines.
+type Stream struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+// NewStream returns a stream writing to w and reading with decoder, which should be the decoder that
+// read the request or response so no buffered data is lost.
+func NewStream(w io.Writer, decoder *json.Decoder) *Stream {
+	return &Stream{encoder: json.NewEncoder(w), decoder: decoder}
+}
+
+func (s *Stream) Send(frame Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(frame)
+}
+
+func (s *Stream) Receive() (Frame, error) {
+	var frame Frame
+	err := s.decoder.Decode(&frame)
+	return frame, err
+}
@@ -0,0 +1,52 @@
+package clipd
+
+import (
+	"os"
+	"time"
+)
+
+type fileState struct {
+	mod  time.Time
+	size int64
+}
+
+// WatchFiles polls the files returned by paths every interval until stop is closed, calling onChange
+// whenever a file's modification time or size differs from the previous poll. Files that first appear
+// in the list are recorded without triggering a change.
+func WatchFiles(paths func() []string, interval time.Duration, stop <-chan struct{}, onChange func()) {
+	states := make(map[string]fileState)
+	poll := func() bool {
+		changed := false
+		seen := make(map[string]bool)
+		for _, path := range paths() {
+			seen[path] = true
+			// A missing file has the zero state, so creating or deleting it counts as a change.
+			var state fileState
+			if info, err := os.Stat(path); err == nil {
+				state = fileState{mod: info.ModTime(), size: info.Size()}
+			}
+			previous, known := states[path]
+			states[path] = state
+			changed = changed || (known && previous != state)
+		}
+		for path := range states {
+			if !seen[path] {
+				delete(states, path)
+			}
+		}
+		return changed
+	}
+	poll()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if poll() {
+			onChange()
+		}
+	}
+}
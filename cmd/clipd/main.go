@@ -1,13 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/trypsynth/clipd/clipd"
+	"golang.org/x/term"
 )
 
 var cfg *clipd.Config
@@ -36,13 +43,175 @@ func main() {
 		Args:  cobra.MinimumNArgs(1),
 		RunE:  runCmdFunc,
 	}
+	runCmd.Flags().BoolP("detach", "d", false, "start the program as a job and print its ID; see clipd job")
+	runCmd.Flags().BoolP("wait", "w", false, "wait for the program to exit and exit with its exit code")
+	runCmd.MarkFlagsMutuallyExclusive("wait", "detach")
+	openCmd := &cobra.Command{
+		Use:   "open <path-or-url>",
+		Short: "Open a file, folder or URL on the Windows machine",
+		Long:  "open hands a file, folder or URL to the Windows shell, which opens it with its default program or applies --verb to it. Local paths must be under a drive mapping; Windows paths and URLs are passed as they are.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  openCmdFunc,
+	}
+	openCmd.Flags().Duration("approval-timeout", 0, "how long to wait if the server requires approval (default: the server's approval timeout)")
+	for _, c := range []*cobra.Command{runCmd, openCmd} {
+		c.Flags().String("verb", "", "shell verb to apply: open, edit, print, explore, properties or runas (default: the file's default action)")
+	}
+	editCmd := &cobra.Command{
+		Use:   "edit <file>",
+		Short: "Edit a file in a Windows editor and wait until it is closed",
+		Long:  "edit opens a file in an editor on the Windows machine and returns once the editor exits, so it can be used as EDITOR. Files outside the drive mappings are copied to the Windows machine, and each save is copied back.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  editCmdFunc,
+	}
+	editCmd.Flags().String("editor", "", "Windows editor program and arguments (default: editor from the config, or notepad.exe)")
+	editCmd.Flags().Duration("approval-timeout", 0, "how long to wait if the server requires approval (default: the server's approval timeout)")
 	pipeCmd := &cobra.Command{
 		Use:   "pipe <program> [args...]",
 		Short: "Pipe stdin to a program on the Windows machine",
 		Args:  cobra.MinimumNArgs(1),
 		RunE:  pipeCmdFunc,
 	}
-	rootCmd.AddCommand(pathCmd, runCmd, pipeCmd)
+	execCmd := &cobra.Command{
+		Use:   "exec <program> [args...]",
+		Short: "Run a program on the Windows machine and stream its output back",
+		Long:  "exec runs a console program on the Windows machine, writes its stdout and stderr to the local stdout and stderr as they arrive, and exits with the program's exit code.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  execCmdFunc,
+	}
+	execCmd.Flags().BoolP("no-stdin", "n", false, "do not forward stdin to the program")
+	shellCmd := &cobra.Command{
+		Use:   "shell [program] [args...]",
+		Short: "Open an interactive shell on the Windows machine",
+		Long:  "shell runs a program, cmd.exe by default, in a pseudo console on the Windows machine and connects it to the local terminal until it exits.",
+		Args:  cobra.ArbitraryArgs,
+		RunE:  shellCmdFunc,
+	}
+	for _, c := range []*cobra.Command{runCmd, pipeCmd, execCmd, shellCmd} {
+		c.Flags().SetInterspersed(false)
+		c.Flags().Duration("approval-timeout", 0, "how long to wait if the server requires approval (default: the server's approval timeout)")
+		c.Flags().StringArrayP("env", "e", nil, "set an environment variable for the program (KEY=VALUE, or KEY to pass the local value)")
+		c.Flags().StringArray("env-file", nil, "read environment variables from a file of KEY=VALUE lines")
+		c.Flags().Bool("clean-env", false, "start the program with only the variables passed instead of the server's environment")
+		c.Flags().Duration("timeout", 0, "kill the program and everything it started if it runs longer than this")
+	}
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Query the server's audit log",
+		Args:  cobra.NoArgs,
+		RunE:  auditCmdFunc,
+	}
+	auditCmd.Flags().String("since", "", "only show entries after this time (RFC 3339, date, or duration like 24h)")
+	auditCmd.Flags().String("until", "", "only show entries before this time (RFC 3339, date, or duration like 1h)")
+	auditCmd.Flags().StringSlice("type", nil, "only show entries of these request types")
+	auditCmd.Flags().String("client", "", "only show entries from this client address")
+	auditCmd.Flags().Int("limit", 100, "maximum number of entries to show, 0 for all")
+	auditCmd.Flags().Bool("json", false, "print entries as JSON lines")
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Print a summary of the server's metrics",
+		Args:  cobra.NoArgs,
+		RunE:  statsCmdFunc,
+	}
+	psCmd := &cobra.Command{
+		Use:   "ps",
+		Short: "List the programs the server started for this client's credential",
+		Args:  cobra.NoArgs,
+		RunE:  psCmdFunc,
+	}
+	killCmd := &cobra.Command{
+		Use:   "kill <id>",
+		Short: "Stop a program listed by ps and every process it started",
+		Args:  cobra.ExactArgs(1),
+		RunE:  killCmdFunc,
+	}
+	waitCmd := &cobra.Command{
+		Use:   "wait <id>",
+		Short: "Wait for a program listed by ps to exit and exit with its exit code",
+		Args:  cobra.ExactArgs(1),
+		RunE:  waitCmdFunc,
+	}
+	jobCmd := &cobra.Command{
+		Use:   "job",
+		Short: "Follow programs started with run --detach",
+	}
+	jobCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List jobs and their state",
+		Args:  cobra.NoArgs,
+		RunE:  jobListCmdFunc,
+	}, &cobra.Command{
+		Use:   "status <id>",
+		Short: "Show the state of a job",
+		Args:  cobra.ExactArgs(1),
+		RunE:  jobStatusCmdFunc,
+	}, &cobra.Command{
+		Use:   "logs <id>",
+		Short: "Print the output a job has written so far",
+		Args:  cobra.ExactArgs(1),
+		RunE:  jobLogsCmdFunc,
+	}, &cobra.Command{
+		Use:   "wait <id>",
+		Short: "Wait for a job to finish and exit with its exit code",
+		Args:  cobra.ExactArgs(1),
+		RunE:  jobWaitCmdFunc,
+	})
+	adminCmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Query and control the running server",
+	}
+	adminCmd.AddCommand(&cobra.Command{
+		Use:   "bans",
+		Short: "List clients locked out after failed authentication",
+		Args:  cobra.NoArgs,
+		RunE:  adminBansCmdFunc,
+	}, &cobra.Command{
+		Use:   "pause",
+		Short: "Stop accepting run and pipe requests",
+		Args:  cobra.NoArgs,
+		RunE:  adminStatusCmdFunc(clipd.AdminVerbPause),
+	}, &cobra.Command{
+		Use:   "resume",
+		Short: "Accept run and pipe requests again",
+		Args:  cobra.NoArgs,
+		RunE:  adminStatusCmdFunc(clipd.AdminVerbResume),
+	}, &cobra.Command{
+		Use:   "reload",
+		Short: "Reload the server's config file",
+		Args:  cobra.NoArgs,
+		RunE:  adminStatusCmdFunc(clipd.AdminVerbReload),
+	}, &cobra.Command{
+		Use:   "status",
+		Short: "Show active connections and processes",
+		Args:  cobra.NoArgs,
+		RunE:  adminStatusCmdFunc(clipd.AdminVerbStatus),
+	}, &cobra.Command{
+		Use:   "kick <id|client>",
+		Short: "Close a connection by ID or all connections from a client address",
+		Args:  cobra.ExactArgs(1),
+		RunE:  adminKickCmdFunc,
+	})
+	policyCmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Inspect the server's program policy",
+	}
+	policyTestCmd := &cobra.Command{
+		Use:   "test <program> [args...]",
+		Short: "Show whether the server's policy would allow running a program",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  policyTestCmdFunc,
+	}
+	policyTestCmd.Flags().String("verb", "", "shell verb the program would be run with, such as runas")
+	policyCmd.AddCommand(policyTestCmd)
+	keygenCmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an Ed25519 key pair for authenticating with the server",
+		Args:  cobra.NoArgs,
+		RunE:  keygenCmdFunc,
+	}
+	keygenCmd.Flags().String("file", "", "where to write the private key; the public key is written next to it with a .pub suffix (default ~/.clipd_ed25519)")
+	keygenCmd.Flags().String("comment", defaultKeyComment(), "comment identifying the key in the server's authorized keys file")
+	rootCmd.AddCommand(pathCmd, runCmd, openCmd, editCmd, pipeCmd, execCmd, shellCmd, auditCmd, statsCmd, psCmd, killCmd, waitCmd, jobCmd, adminCmd, policyCmd, keygenCmd)
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
@@ -54,8 +223,12 @@ func clipboardCmd(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read stdin: %w", err)
 	}
-	serverAddress := fmt.Sprintf("%s:%d", cfg.ServerIP, cfg.ServerPort)
-	return clipd.SendClipboardRequest(serverAddress, string(inputData), cfg.Password)
+	serverAddress := cfg.ServerAddress()
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	return clipd.SendClipboardRequest(serverAddress, string(inputData), auth)
 }
 
 func pathCmdFunc(cmd *cobra.Command, args []string) error {
@@ -71,8 +244,100 @@ func runCmdFunc(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	serverAddress := fmt.Sprintf("%s:%d", cfg.ServerIP, cfg.ServerPort)
-	return clipd.SendRunRequest(serverAddress, program, cmdArgs, workingDir, cfg.Password)
+	serverAddress := cfg.ServerAddress()
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	options, err := runOptions(cmd)
+	if err != nil {
+		return err
+	}
+	options.Verb, _ = cmd.Flags().GetString("verb")
+	if wait, _ := cmd.Flags().GetBool("wait"); wait {
+		status, err := clipd.SendRunWaitRequest(serverAddress, program, cmdArgs, workingDir, options, auth)
+		if err != nil {
+			return err
+		}
+		exitWithStatus(program, status)
+	}
+	if detach, _ := cmd.Flags().GetBool("detach"); detach {
+		job, err := clipd.SendDetachedRunRequest(serverAddress, program, cmdArgs, workingDir, options, auth)
+		if err != nil {
+			return err
+		}
+		fmt.Println(job.ID)
+		return nil
+	}
+	return clipd.SendRunRequest(serverAddress, program, cmdArgs, workingDir, options, auth)
+}
+
+func openCmdFunc(cmd *cobra.Command, args []string) error {
+	target, err := clipd.ResolveTarget(args[0], cfg.DriveMappings)
+	if err != nil {
+		return err
+	}
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	var options clipd.RunOptions
+	options.ApprovalTimeout, _ = cmd.Flags().GetDuration("approval-timeout")
+	options.Verb, _ = cmd.Flags().GetString("verb")
+	return clipd.SendRunRequest(cfg.ServerAddress(), target, nil, "", options, auth)
+}
+
+func editCmdFunc(cmd *cobra.Command, args []string) error {
+	editor, _ := cmd.Flags().GetString("editor")
+	if editor == "" {
+		editor = cfg.Editor
+	}
+	if editor == "" {
+		editor = "notepad.exe"
+	}
+	editorArgs, err := clipd.SplitCommand(editor)
+	if err != nil {
+		return fmt.Errorf("invalid editor: %w", err)
+	}
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	var options clipd.RunOptions
+	options.ApprovalTimeout, _ = cmd.Flags().GetDuration("approval-timeout")
+	var status clipd.ExitStatus
+	if windowsPath := clipd.ResolvePath(path, cfg.DriveMappings); windowsPath != path {
+		workingDir, err := clipd.GetWorkingDir(cfg.DriveMappings)
+		if err != nil {
+			return err
+		}
+		status, err = clipd.SendRunWaitRequest(cfg.ServerAddress(), editorArgs[0], append(editorArgs[1:], windowsPath), workingDir, options, auth)
+		if err != nil {
+			return err
+		}
+	} else {
+		content, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		save := func(content []byte) error {
+			return os.WriteFile(path, content, 0644)
+		}
+		status, err = clipd.SendEditRequest(cfg.ServerAddress(), editorArgs[0], editorArgs[1:], filepath.Base(path), content, save, options, auth)
+		if err != nil {
+			return err
+		}
+	}
+	// Tools using EDITOR only see the exit code, so say why the edit failed.
+	if status.Code != 0 || status.Reason != "" {
+		fmt.Fprintf(os.Stderr, "%s %s\n", editorArgs[0], status)
+		os.Exit(max(exitCode(status.Code), 1))
+	}
+	return nil
 }
 
 func pipeCmdFunc(cmd *cobra.Command, args []string) error {
@@ -82,10 +347,581 @@ func pipeCmdFunc(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	inputData, err := io.ReadAll(os.Stdin)
+	serverAddress := cfg.ServerAddress()
+	auth, err := clientAuth()
 	if err != nil {
-		return fmt.Errorf("failed to read stdin: %w", err)
+		return err
+	}
+	options, err := runOptions(cmd)
+	if err != nil {
+		return err
+	}
+	return clipd.SendPipeRequest(serverAddress, program, cmdArgs, workingDir, os.Stdin, forwardSignals(nil), options, auth)
+}
+
+func execCmdFunc(cmd *cobra.Command, args []string) error {
+	program := clipd.ResolvePath(args[0], cfg.DriveMappings)
+	cmdArgs := clipd.ResolveArgs(args[1:], cfg.DriveMappings)
+	workingDir, err := clipd.GetWorkingDir(cfg.DriveMappings)
+	if err != nil {
+		return err
+	}
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	options, err := runOptions(cmd)
+	if err != nil {
+		return err
+	}
+	var stdin io.Reader = os.Stdin
+	if noStdin, _ := cmd.Flags().GetBool("no-stdin"); noStdin {
+		stdin = nil
+	}
+	sio := clipd.SessionIO{Stdin: stdin, Stdout: os.Stdout, Stderr: os.Stderr, Signals: forwardSignals(nil)}
+	status, err := clipd.SendExecRequest(cfg.ServerAddress(), program, cmdArgs, workingDir, options, auth, sio)
+	if err != nil {
+		return err
+	}
+	exitWithStatus(program, status)
+	return nil
+}
+
+func shellCmdFunc(cmd *cobra.Command, args []string) error {
+	program := "cmd.exe"
+	var cmdArgs []string
+	if len(args) > 0 {
+		program = clipd.ResolvePath(args[0], cfg.DriveMappings)
+		cmdArgs = clipd.ResolveArgs(args[1:], cfg.DriveMappings)
+	}
+	workingDir, err := clipd.GetWorkingDir(cfg.DriveMappings)
+	if err != nil {
+		return err
+	}
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	options, err := runOptions(cmd)
+	if err != nil {
+		return err
+	}
+	size := clipd.TerminalSize{Cols: 80, Rows: 25}
+	sio := clipd.SessionIO{Stdin: os.Stdin, Stdout: os.Stdout}
+	fd := int(os.Stdin.Fd())
+	restore := func() {}
+	// In raw mode Ctrl-C reaches the shell as input; signals only come from outside the terminal.
+	sio.Signals = forwardSignals(func() { restore() })
+	if term.IsTerminal(fd) {
+		if cols, rows, err := term.GetSize(fd); err == nil {
+			size = clipd.TerminalSize{Cols: cols, Rows: rows}
+		}
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("failed to put the terminal in raw mode: %w", err)
+		}
+		restore = func() { term.Restore(fd, state) }
+		sio.Resize = watchTerminalSize(fd)
+	}
+	status, err := clipd.SendShellRequest(cfg.ServerAddress(), program, cmdArgs, workingDir, size, options, auth, sio)
+	restore()
+	if err != nil {
+		return err
+	}
+	exitWithStatus(program, status)
+	return nil
+}
+
+// forwardSignals traps SIGINT and SIGTERM and passes them on to the program of a streaming request:
+// the first Ctrl-C interrupts it, and a second one or SIGTERM terminates it. If the request is not
+// running, the client calls cleanup, if any, and exits.
+func forwardSignals(cleanup func()) <-chan string {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, os.Interrupt, syscall.SIGTERM)
+	signals := make(chan string)
+	go func() {
+		interrupted := false
+		for sig := range received {
+			remote := clipd.SignalTerminate
+			if sig == os.Interrupt && !interrupted {
+				interrupted = true
+				remote = clipd.SignalInterrupt
+			}
+			select {
+			case signals <- remote:
+			case <-time.After(time.Second):
+				if cleanup != nil {
+					cleanup()
+				}
+				os.Exit(130)
+			}
+		}
+	}()
+	return signals
+}
+
+// exitWithStatus exits with the program's exit code, first saying so if it was stopped.
+func exitWithStatus(program string, status clipd.ExitStatus) {
+	if status.Reason != "" {
+		fmt.Fprintf(os.Stderr, "%s %s\n", program, status)
+	}
+	os.Exit(exitCode(status.Code))
+}
+
+// exitCode returns code as an exit code for this process. Only its low 8 bits reach the parent on
+// Unix, so a failure whose low byte is 0, like Windows' 256, becomes 1 rather than success.
+func exitCode(code int) int {
+	if code != 0 && code&0xff == 0 {
+		return 1
+	}
+	return code
+}
+
+// runOptions collects the options of a command that starts a program. Variables listed in the
+// config's forwardEnv are passed first, then those from --env-file, then --env.
+func runOptions(cmd *cobra.Command) (clipd.RunOptions, error) {
+	var options clipd.RunOptions
+	options.ApprovalTimeout, _ = cmd.Flags().GetDuration("approval-timeout")
+	options.CleanEnv, _ = cmd.Flags().GetBool("clean-env")
+	options.Timeout, _ = cmd.Flags().GetDuration("timeout")
+	options.Env = clipd.ForwardedEnv(cfg.ForwardEnv)
+	envFiles, _ := cmd.Flags().GetStringArray("env-file")
+	for _, path := range envFiles {
+		if err := clipd.ReadEnvFile(path, options.Env); err != nil {
+			return options, err
+		}
+	}
+	assignments, _ := cmd.Flags().GetStringArray("env")
+	for _, assignment := range assignments {
+		if err := clipd.ParseEnvAssignment(assignment, options.Env); err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+func auditCmdFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	var filter clipd.AuditFilter
+	var err error
+	since, _ := flags.GetString("since")
+	if filter.Since, err = parseTimeFlag(since); err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	until, _ := flags.GetString("until")
+	if filter.Until, err = parseTimeFlag(until); err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+	filter.Types, _ = flags.GetStringSlice("type")
+	for _, t := range filter.Types {
+		if _, err := clipd.ParseRequestType(t); err != nil {
+			return err
+		}
+	}
+	filter.Client, _ = flags.GetString("client")
+	filter.Limit, _ = flags.GetInt("limit")
+	asJSON, _ := flags.GetBool("json")
+	serverAddress := cfg.ServerAddress()
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	entries, err := clipd.SendAuditRequest(serverAddress, filter, auth)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, entry := range entries {
+		if asJSON {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Println(formatAuditEntry(entry))
+	}
+	return nil
+}
+
+func formatAuditEntry(entry clipd.AuditEntry) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s  %-15s  %-9s  %-12s", entry.Time.Local().Format(time.DateTime), entry.Client, entry.Type, entry.Result)
+	if entry.Verb != "" {
+		fmt.Fprintf(&sb, "  %s", entry.Verb)
+	}
+	if entry.Program != "" {
+		fmt.Fprintf(&sb, "  %s", entry.Program)
+		for _, arg := range entry.Args {
+			fmt.Fprintf(&sb, " %q", arg)
+		}
+	}
+	if entry.WorkingDir != "" {
+		fmt.Fprintf(&sb, "  (in %s)", entry.WorkingDir)
+	}
+	if entry.ContentHash != "" {
+		// Entries come from a file that may have been edited, so the hash is not trusted to be complete.
+		fmt.Fprintf(&sb, "  sha256:%s (%d bytes)", entry.ContentHash[:min(len(entry.ContentHash), 12)], entry.ContentSize)
+	}
+	if entry.Error != "" {
+		fmt.Fprintf(&sb, "  error: %s", entry.Error)
+	}
+	return sb.String()
+}
+
+// parseTimeFlag accepts an RFC 3339 timestamp, a date, or a duration counted back from now.
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
+}
+
+func statsCmdFunc(cmd *cobra.Command, args []string) error {
+	serverAddress := cfg.ServerAddress()
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	stats, err := clipd.SendStatsRequest(serverAddress, auth)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Active connections: %d\n", stats.ActiveConnections)
+	fmt.Printf("Rejected:           %d\n", stats.RejectedConns)
+	fmt.Printf("Processes spawned:  %d\n", stats.ProcessesSpawned)
+	fmt.Printf("Auth failures:      %d\n", stats.AuthFailures)
+	fmt.Printf("Bytes received:     %d\n", stats.BytesReceived)
+	if len(stats.Requests) > 0 {
+		fmt.Println("\nRequests:")
+		for _, r := range stats.Requests {
+			fmt.Printf("  %-10s %-14s %d\n", r.Type, r.Result, r.Count)
+		}
+	}
+	if len(stats.Latency) > 0 {
+		fmt.Println("\nAverage latency:")
+		for _, l := range stats.Latency {
+			avg := time.Duration(l.TotalSeconds / float64(l.Count) * float64(time.Second))
+			fmt.Printf("  %-10s %v\n", l.Type, avg.Round(time.Microsecond))
+		}
+	}
+	return nil
+}
+
+func psCmdFunc(cmd *cobra.Command, args []string) error {
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	list, err := clipd.SendProcessListRequest(cfg.ServerAddress(), auth)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("No processes.")
+		return nil
+	}
+	printProcessHeader()
+	for _, info := range list {
+		fmt.Println(formatProcess(info))
+	}
+	return nil
+}
+
+func printProcessHeader() {
+	fmt.Printf("%-5s %-6s %-9s %-19s %-15s %-8s %s\n", "ID", "PID", "STATE", "STARTED", "CLIENT", "TYPE", "PROGRAM")
+}
+
+func formatProcess(info clipd.ProcessInfo) string {
+	var sb strings.Builder
+	state := info.State
+	if info.State == clipd.ProcessExited {
+		state = fmt.Sprintf("exit %d", *info.ExitCode)
+	}
+	fmt.Fprintf(&sb, "%-5d %-6d %-9s %-19s %-15s %-8s %s", info.ID, info.PID, state, info.Started.Local().Format(time.DateTime), info.Client, info.Type, info.Program)
+	for _, arg := range info.Args {
+		fmt.Fprintf(&sb, " %q", arg)
+	}
+	if info.Reason != "" {
+		fmt.Fprintf(&sb, "  (%s)", info.Reason)
+	}
+	return sb.String()
+}
+
+func killCmdFunc(cmd *cobra.Command, args []string) error {
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	info, err := clipd.SendProcessRequest(cfg.ServerAddress(), clipd.ProcessVerbKill, args[0], auth)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Killed %d (%s, PID %d)\n", info.ID, info.Program, info.PID)
+	return nil
+}
+
+func waitCmdFunc(cmd *cobra.Command, args []string) error {
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	info, err := clipd.SendProcessRequest(cfg.ServerAddress(), clipd.ProcessVerbWait, args[0], auth)
+	if err != nil {
+		return err
+	}
+	exitWithStatus(info.Program, info.Status())
+	return nil
+}
+
+func jobListCmdFunc(cmd *cobra.Command, args []string) error {
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	list, err := clipd.SendJobListRequest(cfg.ServerAddress(), auth)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("No jobs.")
+		return nil
+	}
+	printProcessHeader()
+	for _, job := range list {
+		fmt.Println(formatProcess(job.ProcessInfo))
+	}
+	return nil
+}
+
+func jobStatusCmdFunc(cmd *cobra.Command, args []string) error {
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	job, err := clipd.SendJobStatusRequest(cfg.ServerAddress(), args[0], false, auth)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Job %d: %s", job.ID, job.Program)
+	for _, arg := range job.Args {
+		fmt.Printf(" %q", arg)
+	}
+	fmt.Printf("\nPID:     %d\n", job.PID)
+	fmt.Printf("Started: %s\n", job.Started.Local().Format(time.DateTime))
+	if job.State == clipd.ProcessExited {
+		fmt.Printf("Ended:   %s (after %v)\n", job.Ended.Local().Format(time.DateTime), job.Ended.Sub(job.Started).Round(time.Second))
+		fmt.Printf("Status:  %s\n", job.Status())
+	} else {
+		fmt.Printf("Status:  running for %v\n", time.Since(job.Started).Round(time.Second))
+	}
+	if job.OutputTruncated {
+		fmt.Println("Only the most recent output was kept.")
+	}
+	return nil
+}
+
+func jobLogsCmdFunc(cmd *cobra.Command, args []string) error {
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	logs, err := clipd.SendJobLogsRequest(cfg.ServerAddress(), args[0], auth)
+	if err != nil {
+		return err
+	}
+	if logs.Truncated {
+		fmt.Fprintln(os.Stderr, "(earlier output was dropped)")
+	}
+	os.Stdout.Write(logs.Stdout)
+	os.Stderr.Write(logs.Stderr)
+	return nil
+}
+
+func jobWaitCmdFunc(cmd *cobra.Command, args []string) error {
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	job, err := clipd.SendJobStatusRequest(cfg.ServerAddress(), args[0], true, auth)
+	if err != nil {
+		return err
+	}
+	exitWithStatus(job.Program, job.Status())
+	return nil
+}
+
+// clientAuth returns the password and, if an identity file is configured, the private key to authenticate with.
+func clientAuth() (clipd.Auth, error) {
+	auth := clipd.Auth{Password: cfg.Password}
+	if cfg.IdentityFile != "" {
+		key, err := clipd.LoadPrivateKey(cfg.IdentityFile)
+		if err != nil {
+			return auth, err
+		}
+		auth.Key = key
+	}
+	return auth, nil
+}
+
+func adminAuth() (clipd.Auth, error) {
+	auth, err := clientAuth()
+	auth.Password = adminPassword()
+	return auth, err
+}
+
+func adminPassword() string {
+	if cfg.AdminPassword != "" {
+		return cfg.AdminPassword
+	}
+	return cfg.Password
+}
+
+func adminStatusCmdFunc(verb string) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		serverAddress := cfg.ServerAddress()
+		auth, err := adminAuth()
+		if err != nil {
+			return err
+		}
+		data, err := clipd.SendAdminRequest(serverAddress, verb, nil, auth)
+		if err != nil {
+			return err
+		}
+		var status clipd.ServerStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			return fmt.Errorf("error decoding status: %w", err)
+		}
+		state := "accepting requests"
+		if status.Paused {
+			state = "paused"
+		}
+		fmt.Printf("Server %s, up since %s\n", state, status.Started.Local().Format(time.DateTime))
+		fmt.Printf("\nConnections (%d):\n", len(status.Connections))
+		for _, active := range status.Connections {
+			fmt.Println("  " + formatActiveRequest(active))
+		}
+		fmt.Printf("\nProcesses (%d):\n", len(status.Processes))
+		if len(status.Processes) > 0 {
+			fmt.Print("  ")
+			printProcessHeader()
+		}
+		for _, info := range status.Processes {
+			fmt.Println("  " + formatProcess(info))
+		}
+		return nil
+	}
+}
+
+func adminKickCmdFunc(cmd *cobra.Command, args []string) error {
+	serverAddress := cfg.ServerAddress()
+	auth, err := adminAuth()
+	if err != nil {
+		return err
+	}
+	data, err := clipd.SendAdminRequest(serverAddress, clipd.AdminVerbKick, args, auth)
+	if err != nil {
+		return err
+	}
+	var kicked []clipd.ActiveRequest
+	if err := json.Unmarshal(data, &kicked); err != nil {
+		return fmt.Errorf("error decoding kicked connections: %w", err)
+	}
+	if len(kicked) == 0 {
+		return fmt.Errorf("no connection matches %q", args[0])
+	}
+	for _, active := range kicked {
+		fmt.Println("Kicked " + formatActiveRequest(active))
+	}
+	return nil
+}
+
+func formatActiveRequest(active clipd.ActiveRequest) string {
+	description := fmt.Sprintf("%-5d %-15s %-9s", active.ID, active.Client, active.Type)
+	if active.Detail != "" {
+		description += " " + active.Detail
+	}
+	return fmt.Sprintf("%s  (%v)", description, time.Since(active.Started).Round(time.Second))
+}
+
+func adminBansCmdFunc(cmd *cobra.Command, args []string) error {
+	serverAddress := cfg.ServerAddress()
+	auth, err := adminAuth()
+	if err != nil {
+		return err
+	}
+	data, err := clipd.SendAdminRequest(serverAddress, clipd.AdminVerbBans, nil, auth)
+	if err != nil {
+		return err
+	}
+	var bans []clipd.Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return fmt.Errorf("error decoding bans: %w", err)
+	}
+	if len(bans) == 0 {
+		fmt.Println("No clients are locked out.")
+		return nil
+	}
+	for _, ban := range bans {
+		state := "delayed"
+		if ban.Banned {
+			state = "banned"
+		}
+		fmt.Printf("%-15s  %-7s  until %s  (%d failures, %d bans)\n", ban.Client, state, ban.Until.Local().Format(time.DateTime), ban.Failures, ban.Bans)
+	}
+	return nil
+}
+
+func policyTestCmdFunc(cmd *cobra.Command, args []string) error {
+	program := clipd.ResolvePath(args[0], cfg.DriveMappings)
+	cmdArgs := clipd.ResolveArgs(args[1:], cfg.DriveMappings)
+	workingDir, err := clipd.GetWorkingDir(cfg.DriveMappings)
+	if err != nil {
+		return err
+	}
+	auth, err := clientAuth()
+	if err != nil {
+		return err
+	}
+	verb, _ := cmd.Flags().GetString("verb")
+	decision, err := clipd.SendPolicyTestRequest(cfg.ServerAddress(), program, cmdArgs, workingDir, verb, auth)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", decision.Program, decision)
+	return nil
+}
+
+func keygenCmdFunc(cmd *cobra.Command, args []string) error {
+	file, _ := cmd.Flags().GetString("file")
+	comment, _ := cmd.Flags().GetString("comment")
+	if file == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get user home directory: %w", err)
+		}
+		file = filepath.Join(homeDir, ".clipd_ed25519")
+	}
+	public, err := clipd.GenerateKeyFiles(file, comment)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote private key to %s and public key to %s.pub\n", file, file)
+	fmt.Printf("Set \"identityFile\": %q in your config and add this line to the server's authorized keys file:\n\n", file)
+	fmt.Println(clipd.FormatPublicKey(public, comment))
+	return nil
+}
+
+func defaultKeyComment() string {
+	host, _ := os.Hostname()
+	user := os.Getenv("USER")
+	if user == "" || host == "" {
+		return user + host
 	}
-	serverAddress := fmt.Sprintf("%s:%d", cfg.ServerIP, cfg.ServerPort)
-	return clipd.SendPipeRequest(serverAddress, program, cmdArgs, workingDir, string(inputData), cfg.Password)
+	return user + "@" + host
 }
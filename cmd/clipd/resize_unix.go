@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/trypsynth/clipd/clipd"
+	"golang.org/x/term"
+)
+
+// watchTerminalSize reports the size of the terminal on fd each time it changes.
+func watchTerminalSize(fd int) <-chan clipd.TerminalSize {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGWINCH)
+	sizes := make(chan clipd.TerminalSize, 1)
+	go func() {
+		for range signals {
+			cols, rows, err := term.GetSize(fd)
+			if err != nil {
+				continue
+			}
+			// Only the latest size matters, so replace one that has not been sent yet.
+			select {
+			case <-sizes:
+			default:
+			}
+			sizes <- clipd.TerminalSize{Cols: cols, Rows: rows}
+		}
+	}()
+	return sizes
+}
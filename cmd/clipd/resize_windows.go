@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "github.com/trypsynth/clipd/clipd"
+
+// watchTerminalSize returns nil on Windows, which has no resize signal; the shell keeps its initial size.
+func watchTerminalSize(fd int) <-chan clipd.TerminalSize {
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+func handleAdmin(req *request) (json.RawMessage, error) {
+	switch req.Data {
+	case clipd.AdminVerbBans:
+		return json.Marshal(guard.Bans())
+	case clipd.AdminVerbPause:
+		paused.Store(true)
+		log.Printf("Paused run and pipe requests (credential %s from %s)", req.credential.Name, req.client)
+		return json.Marshal(serverStatus())
+	case clipd.AdminVerbResume:
+		paused.Store(false)
+		log.Printf("Resumed run and pipe requests (credential %s from %s)", req.credential.Name, req.client)
+		return json.Marshal(serverStatus())
+	case clipd.AdminVerbReload:
+		if err := reloadConfig(); err != nil {
+			return nil, fmt.Errorf("Config reload failed: %v", err)
+		}
+		return json.Marshal(serverStatus())
+	case clipd.AdminVerbStatus:
+		return json.Marshal(serverStatus())
+	case clipd.AdminVerbKick:
+		if len(req.Args) != 1 {
+			return nil, fmt.Errorf("kick needs a connection ID or client address")
+		}
+		kicked := supervisor.Kick(req.Args[0], req.activity.ID())
+		for _, k := range kicked {
+			log.Printf("Kicked connection %d from %s (%s %s)", k.ID, k.Client, k.Type, k.Detail)
+		}
+		return json.Marshal(kicked)
+	default:
+		return nil, fmt.Errorf("Unknown admin verb: %q", req.Data)
+	}
+}
+
+func serverStatus() clipd.ServerStatus {
+	return clipd.ServerStatus{
+		Paused:      paused.Load(),
+		Started:     started,
+		Connections: supervisor.Active(),
+		Processes:   processes.List(""),
+	}
+}
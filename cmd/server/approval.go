@@ -0,0 +1,96 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+var (
+	messageBoxTimeoutW = user32.NewProc("MessageBoxTimeoutW")
+	terminalApprover   = &clipd.TerminalApprover{In: os.Stdin, Out: os.Stdout}
+)
+
+const (
+	mbYesNo          = 0x00000004
+	mbIconQuestion   = 0x00000020
+	mbDefButton2     = 0x00000100
+	mbSystemModal    = 0x00001000
+	mbSetForeground  = 0x00010000
+	mbTopmost        = 0x00040000
+	idYes            = 6
+	mbTimedOut       = 32000
+	approvalBoxTitle = "Clipd: approve program?"
+)
+
+// dialogApprover asks on the desktop with a message box that closes itself when the approval times out.
+type dialogApprover struct{}
+
+func (dialogApprover) Approve(ctx context.Context, req clipd.ApprovalRequest) (bool, error) {
+	timeout := time.Minute
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 {
+		return false, context.DeadlineExceeded
+	}
+	message, err := windows.UTF16PtrFromString(fmt.Sprintf("A client wants to run a program.\n\n%s\n\nAllow it?", req))
+	if err != nil {
+		return false, err
+	}
+	title, err := windows.UTF16PtrFromString(approvalBoxTitle)
+	if err != nil {
+		return false, err
+	}
+	flags := uintptr(mbYesNo | mbIconQuestion | mbDefButton2 | mbSystemModal | mbSetForeground | mbTopmost)
+	ret, _, callErr := messageBoxTimeoutW.Call(0, uintptr(unsafe.Pointer(message)), uintptr(unsafe.Pointer(title)), flags, 0, uintptr(timeout.Milliseconds()))
+	switch ret {
+	case 0:
+		return false, fmt.Errorf("failed to show approval dialog: %v", callErr)
+	case mbTimedOut:
+		return false, context.DeadlineExceeded
+	}
+	return ret == idYes, nil
+}
+
+func configuredApprover(cfg *clipd.Config) clipd.Approver {
+	if cfg.Server.Approval.Approver == clipd.ApproverTerminal {
+		return terminalApprover
+	}
+	return dialogApprover{}
+}
+
+// approve asks for confirmation of a run or pipe request matching a requireApproval rule. The client
+// may ask for a shorter wait than the server's approval timeout but not a longer one.
+func approve(r *request, decision clipd.PolicyDecision) error {
+	cfg := config.Load()
+	timeout := time.Duration(cfg.Server.Approval.Timeout)
+	if r.ApprovalTimeout > 0 && time.Duration(r.ApprovalTimeout) < timeout {
+		timeout = time.Duration(r.ApprovalTimeout)
+	}
+	req := clipd.ApprovalRequest{
+		Program:    decision.Program,
+		Args:       r.Args,
+		WorkingDir: r.WorkingDir,
+		Verb:       r.Verb,
+		Client:     r.client,
+		Credential: r.credential.Name,
+		Rule:       decision.Name,
+	}
+	r.activity.Describe(r.Type.String(), decision.Program+" (awaiting approval)")
+	defer r.activity.Describe(r.Type.String(), r.Data)
+	err := clipd.RequestApproval(configuredApprover(cfg), timeout, req)
+	if err == nil {
+		log.Printf("Approved running %s for %s (credential %s)", decision.Program, r.client, r.credential.Name)
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+var updateProcThreadAttribute = kernel32.NewProc("UpdateProcThreadAttribute")
+
+// processLauncher starts the processes of exec and shell requests.
+type processLauncher struct{}
+
+var launcher clipd.Launcher = processLauncher{}
+
+func (processLauncher) Launch(spec clipd.LaunchSpec) (clipd.Process, error) {
+	if spec.Terminal != nil {
+		process, err := startConsoleProcess(spec.Program, spec.Args, spec.WorkingDir, spec.Env, spec.Limits, *spec.Terminal)
+		if err != nil {
+			return nil, err
+		}
+		return process, nil
+	}
+	process, err := startProcess(spec.Program, spec.Args, spec.WorkingDir, processOptions{stdin: true, output: true, env: spec.Env, limits: spec.Limits})
+	if err != nil {
+		return nil, err
+	}
+	return process, nil
+}
+
+// consoleProcess is a program attached to a pseudo console, so it behaves as if run in a terminal.
+type consoleProcess struct {
+	mu      sync.Mutex
+	handle  windows.Handle
+	pid     int
+	console windows.Handle
+	job     *job
+	input   *handleWriter
+	output  *os.File
+	exited  chan struct{}
+	code    uint32
+	err     error
+}
+
+func startConsoleProcess(program string, args []string, workingDir string, env []string, limits clipd.ProcessLimits, size clipd.TerminalSize) (*consoleProcess, error) {
+	resolvedProgram, err := resolveExecutable(program)
+	if err != nil {
+		return nil, err
+	}
+	lpFile, err := clipd.ToUTF16Ptr(resolvedProgram, "program path")
+	if err != nil {
+		return nil, err
+	}
+	lpDirectory, err := clipd.OptionalUTF16Ptr(workingDir, "working directory")
+	if err != nil {
+		return nil, err
+	}
+	cmdLine, err := windows.UTF16FromString(buildCommandLine(resolvedProgram, args))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build command line: %w", err)
+	}
+	envBlock, err := environmentBlock(env)
+	if err != nil {
+		return nil, err
+	}
+	var consoleIn, inputWrite, outputRead, consoleOut windows.Handle
+	if err := windows.CreatePipe(&consoleIn, &inputWrite, nil, 0); err != nil {
+		return nil, fmt.Errorf("failed to create console input pipe: %w", err)
+	}
+	defer closeHandle(&consoleIn)
+	if err := windows.CreatePipe(&outputRead, &consoleOut, nil, 0); err != nil {
+		windows.CloseHandle(inputWrite)
+		return nil, fmt.Errorf("failed to create console output pipe: %w", err)
+	}
+	defer closeHandle(&consoleOut)
+	var console windows.Handle
+	if err := windows.CreatePseudoConsole(consoleSize(size), consoleIn, consoleOut, 0, &console); err != nil {
+		windows.CloseHandle(inputWrite)
+		windows.CloseHandle(outputRead)
+		return nil, fmt.Errorf("failed to create pseudo console: %w", err)
+	}
+	fail := func(err error) (*consoleProcess, error) {
+		windows.ClosePseudoConsole(console)
+		windows.CloseHandle(inputWrite)
+		windows.CloseHandle(outputRead)
+		return nil, err
+	}
+	attributes, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return fail(fmt.Errorf("failed to allocate process attributes: %w", err))
+	}
+	defer attributes.Delete()
+	// The pseudo console attribute takes the handle itself rather than a pointer to it.
+	ret, _, callErr := updateProcThreadAttribute.Call(uintptr(unsafe.Pointer(attributes.List())), 0, windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE, uintptr(console), unsafe.Sizeof(console), 0, 0)
+	if ret == 0 {
+		return fail(fmt.Errorf("failed to attach pseudo console: %v", callErr))
+	}
+	startupInfo := &windows.StartupInfoEx{ProcThreadAttributeList: attributes.List()}
+	startupInfo.Cb = uint32(unsafe.Sizeof(*startupInfo))
+	var procInfo windows.ProcessInformation
+	consoleMu.Lock()
+	err = windows.CreateProcess(lpFile, &cmdLine[0], nil, nil, false, windows.EXTENDED_STARTUPINFO_PRESENT|windows.CREATE_SUSPENDED|windows.CREATE_UNICODE_ENVIRONMENT, envBlock, lpDirectory, &startupInfo.StartupInfo, &procInfo)
+	consoleMu.Unlock()
+	if err != nil {
+		return fail(fmt.Errorf("CreateProcess failed: %w", err))
+	}
+	metrics.ProcessSpawned()
+	job, err := resumeInJob(&procInfo, limits)
+	if err != nil {
+		return fail(err)
+	}
+	windows.CloseHandle(procInfo.Thread)
+	p := &consoleProcess{
+		handle:  procInfo.Process,
+		pid:     int(procInfo.ProcessId),
+		console: console,
+		job:     job,
+		input:   &handleWriter{handle: inputWrite},
+		output:  os.NewFile(uintptr(outputRead), "console"),
+		exited:  make(chan struct{}),
+	}
+	go p.wait()
+	return p, nil
+}
+
+// wait records the exit code and closes the pseudo console, which ends its output once drained.
+func (p *consoleProcess) wait() {
+	defer close(p.exited)
+	if _, err := windows.WaitForSingleObject(p.handle, windows.INFINITE); err != nil {
+		p.err = err
+	} else {
+		p.err = windows.GetExitCodeProcess(p.handle, &p.code)
+	}
+	p.mu.Lock()
+	windows.ClosePseudoConsole(p.console)
+	p.console = 0
+	closeHandle(&p.handle)
+	p.mu.Unlock()
+	p.input.Close()
+}
+
+func consoleSize(size clipd.TerminalSize) windows.Coord {
+	return windows.Coord{X: int16(min(size.Cols, 0x7fff)), Y: int16(min(size.Rows, 0x7fff))}
+}
+
+func (p *consoleProcess) PID() int {
+	return p.pid
+}
+
+func (p *consoleProcess) Stdin() io.WriteCloser {
+	return p.input
+}
+
+func (p *consoleProcess) Stdout() io.Reader {
+	return p.output
+}
+
+func (p *consoleProcess) Stderr() io.Reader {
+	return nil
+}
+
+func (p *consoleProcess) Wait() (int, error) {
+	<-p.exited
+	p.output.Close()
+	p.job.close()
+	return int(p.code), p.err
+}
+
+// Kill terminates the process and everything it started.
+func (p *consoleProcess) Kill() error {
+	return p.job.kill("")
+}
+
+func (p *consoleProcess) ExceededLimit() string {
+	return p.job.exceededLimit()
+}
+
+// Interrupt types Ctrl-C into the pseudo console, which delivers it like a terminal would.
+func (p *consoleProcess) Interrupt() error {
+	_, err := p.input.Write([]byte{0x03})
+	return err
+}
+
+func (p *consoleProcess) Resize(size clipd.TerminalSize) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.console == 0 {
+		return nil
+	}
+	return windows.ResizePseudoConsole(p.console, consoleSize(size))
+}
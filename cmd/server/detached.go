@@ -0,0 +1,100 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+// startJob starts the program of a detached run request. Its output and exit status are kept in the
+// job store, so the client can disconnect and collect them later.
+func startJob(r *request) (json.RawMessage, error) {
+	process, err := startProcess(r.Data, r.Args, r.WorkingDir, processOptions{output: true, env: processEnvironment(r), limits: processLimits(r)})
+	if err != nil {
+		return nil, fmt.Errorf("Program execution failed: %v", err)
+	}
+	launched := processLaunched(r, process.PID(), process.Kill)
+	job := jobs.Add(launched.info)
+	go runJob(process, launched, job)
+	return json.Marshal(clipd.JobInfo{ProcessInfo: launched.info})
+}
+
+// runJob records the output of a job's process until it ends, then its exit status.
+func runJob(process *pipeProcess, launched launchedProcess, job *clipd.Job) {
+	var wg sync.WaitGroup
+	for _, output := range []struct {
+		r io.Reader
+		w io.Writer
+	}{{process.Stdout(), job.Stdout()}, {process.Stderr(), job.Stderr()}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(output.w, output.r)
+		}()
+	}
+	wg.Wait()
+	code, err := process.Wait()
+	status := clipd.ExitStatus{Code: code, Reason: process.ExceededLimit()}
+	if err != nil {
+		log.Printf("Failed to wait for job %d (process %d): %v", launched.info.ID, process.PID(), err)
+		launched.lost()
+		status = clipd.ExitStatus{Code: -1}
+	} else {
+		launched.exited(status)
+	}
+	info := job.Finish(status)
+	event := launched.event
+	event.Name = clipd.EventJobCompleted
+	event.Job = info.ID
+	event.ExitCode = info.ExitCode
+	event.Reason = info.Reason
+	hooks.Emit(event)
+}
+
+// handleJob answers queries about detached jobs. Like processes, clients see the jobs their credential
+// started and admin credentials see all of them.
+func handleJob(req *request) (json.RawMessage, error) {
+	owner := req.credential.Name
+	if req.credential.IsAdmin() {
+		owner = ""
+	}
+	if req.Data == clipd.JobVerbList {
+		return json.Marshal(jobs.List(owner))
+	}
+	if len(req.Args) != 1 {
+		return nil, fmt.Errorf("%s needs a job ID", req.Data)
+	}
+	id, err := strconv.Atoi(req.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid job ID %q", req.Args[0])
+	}
+	switch req.Data {
+	case clipd.JobVerbStatus:
+		info, err := jobs.Status(id, owner)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(info)
+	case clipd.JobVerbLogs:
+		logs, err := jobs.Logs(id, owner)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(logs)
+	case clipd.JobVerbWait:
+		info, err := jobs.Wait(id, owner, connClosed(req.conn))
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(info)
+	default:
+		return nil, fmt.Errorf("Unknown job verb: %q", req.Data)
+	}
+}
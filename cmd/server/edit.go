@@ -0,0 +1,77 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+// waitForProgram answers a run request that waits for its program once the process with id exits.
+func waitForProgram(r *request, id int) (json.RawMessage, error) {
+	if id == 0 {
+		return nil, fmt.Errorf("%s was opened without a process to wait for", r.Data)
+	}
+	info, err := processes.Wait(id, "", connClosed(r.conn))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(info)
+}
+
+// startEdit writes the file of an edit request to a temporary directory and opens it in the editor.
+// The stream then sends the client each save until the editor exits.
+func startEdit(r *request) error {
+	name := filepath.Base(r.FileName)
+	if name != r.FileName || name == "." || name == ".." {
+		return fmt.Errorf("invalid file name %q", r.FileName)
+	}
+	dir, err := os.MkdirTemp("", "clipd-edit-")
+	if err != nil {
+		return fmt.Errorf("failed to create directory for edited file: %w", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, r.File, 0600); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to write edited file: %w", err)
+	}
+	process, err := shellLauncher.ShellExecute(clipd.ShellSpec{File: r.Data, Args: append(slices.Clone(r.Args), path), WorkingDir: r.WorkingDir})
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("Program execution failed: %v", err)
+	}
+	id := watchProcess(windows.Handle(process), r)
+	if id == 0 {
+		// The editor may still have the file open, so it is left for the system to clean up.
+		return fmt.Errorf("%s was opened without a process to wait for", r.Data)
+	}
+	r.stream = func(stream *clipd.Stream) { serveEdit(stream, r, id, dir, path) }
+	return nil
+}
+
+func serveEdit(stream *clipd.Stream, r *request, id int, dir, path string) {
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Failed to remove edited file %s: %v", path, err)
+		}
+	}()
+	exited := make(chan clipd.ExitStatus, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		if info, err := processes.Wait(id, "", stop); err == nil {
+			exited <- info.Status()
+		}
+	}()
+	if err := clipd.ServeEdit(stream, path, r.File, exited); err != nil {
+		log.Printf("Edit of %s in %s for %s: %v", r.FileName, r.Data, r.client, err)
+	}
+}
@@ -0,0 +1,215 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+var (
+	attachConsole             = kernel32.NewProc("AttachConsole")
+	freeConsole               = kernel32.NewProc("FreeConsole")
+	setConsoleCtrlHandler     = kernel32.NewProc("SetConsoleCtrlHandler")
+	getQueuedCompletionStatus = kernel32.NewProc("GetQueuedCompletionStatus")
+)
+
+const JOB_OBJECT_MSG_PROCESS_MEMORY_LIMIT = 9
+
+type JOBOBJECT_ASSOCIATE_COMPLETION_PORT struct {
+	CompletionKey  uintptr
+	CompletionPort windows.Handle
+}
+
+// Jobs with a memory limit report through a completion port when a process reaches it; jobPort
+// is created on first use and jobsByKey maps its completion keys back to jobs.
+var (
+	jobPortOnce sync.Once
+	jobPort     windows.Handle
+	jobPortErr  error
+	jobsMu      sync.Mutex
+	jobsByKey   = make(map[uintptr]*job)
+	nextJobKey  uintptr
+)
+
+// consoleMu is held while the server sends Ctrl-C to a console and while it starts processes. The
+// server ignores Ctrl-C while sending it, and processes started then would inherit that.
+var consoleMu sync.Mutex
+
+// job is a job object holding a started process and every process it starts, so that the whole tree
+// can be terminated together and held to its limits.
+type job struct {
+	mu     sync.Mutex
+	handle windows.Handle
+	key    uintptr
+	timer  *time.Timer
+	// limit is the limit the job was killed for.
+	limit string
+}
+
+// newJob creates a job object enforcing limits. The runtime limit counts from now.
+func newJob(limits clipd.ProcessLimits) (*job, error) {
+	handle, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job object: %w", err)
+	}
+	j := &job{handle: handle}
+	if limits.MaxMemory > 0 {
+		if err := j.limitMemory(limits.MaxMemory); err != nil {
+			j.close()
+			return nil, err
+		}
+	}
+	if limits.MaxRuntime > 0 {
+		j.timer = time.AfterFunc(limits.MaxRuntime, func() { j.kill(clipd.ExitTimedOut) })
+	}
+	return j, nil
+}
+
+// limitMemory caps the memory each process in the job may commit. Windows fails allocations past the
+// limit and reports it on jobPort, where watchJobs kills the job.
+func (j *job) limitMemory(limit uint64) error {
+	var info windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_PROCESS_MEMORY
+	info.ProcessMemoryLimit = uintptr(limit)
+	if _, err := windows.SetInformationJobObject(j.handle, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		return fmt.Errorf("failed to set job memory limit: %w", err)
+	}
+	jobPortOnce.Do(func() {
+		jobPort, jobPortErr = windows.CreateIoCompletionPort(windows.InvalidHandle, 0, 0, 1)
+		if jobPortErr == nil {
+			go watchJobs(jobPort)
+		}
+	})
+	if jobPortErr != nil {
+		return fmt.Errorf("failed to create job completion port: %w", jobPortErr)
+	}
+	jobsMu.Lock()
+	nextJobKey++
+	j.key = nextJobKey
+	jobsByKey[j.key] = j
+	jobsMu.Unlock()
+	port := JOBOBJECT_ASSOCIATE_COMPLETION_PORT{CompletionKey: j.key, CompletionPort: jobPort}
+	if _, err := windows.SetInformationJobObject(j.handle, windows.JobObjectAssociateCompletionPortInformation, uintptr(unsafe.Pointer(&port)), uint32(unsafe.Sizeof(port))); err != nil {
+		return fmt.Errorf("failed to watch job: %w", err)
+	}
+	return nil
+}
+
+// watchJobs kills jobs whose processes reach their memory limit.
+func watchJobs(port windows.Handle) {
+	for {
+		// For job notifications the overlapped pointer carries a process ID, so it is read as a uintptr.
+		var message uint32
+		var key, overlapped uintptr
+		ret, _, err := getQueuedCompletionStatus.Call(uintptr(port), uintptr(unsafe.Pointer(&message)), uintptr(unsafe.Pointer(&key)), uintptr(unsafe.Pointer(&overlapped)), uintptr(windows.INFINITE))
+		if ret == 0 {
+			log.Printf("Failed to read job notifications: %v", err)
+			return
+		}
+		if message != JOB_OBJECT_MSG_PROCESS_MEMORY_LIMIT {
+			continue
+		}
+		jobsMu.Lock()
+		j := jobsByKey[key]
+		jobsMu.Unlock()
+		if j != nil {
+			j.kill(clipd.ExitMemoryLimit)
+		}
+	}
+}
+
+// assign puts process in the job.
+func (j *job) assign(process windows.Handle) error {
+	if err := windows.AssignProcessToJobObject(j.handle, process); err != nil {
+		return fmt.Errorf("failed to assign process to job object: %w", err)
+	}
+	return nil
+}
+
+// resumeInJob puts the suspended process of procInfo in a new job object and lets it run. If that
+// fails the process is terminated and its handles are closed.
+func resumeInJob(procInfo *windows.ProcessInformation, limits clipd.ProcessLimits) (*job, error) {
+	j, err := newJob(limits)
+	if err == nil {
+		if err = j.assign(procInfo.Process); err == nil {
+			if _, err = windows.ResumeThread(procInfo.Thread); err != nil {
+				err = fmt.Errorf("failed to resume process: %w", err)
+			}
+		}
+		if err != nil {
+			j.close()
+		}
+	}
+	if err != nil {
+		windows.TerminateProcess(procInfo.Process, 1)
+		windows.CloseHandle(procInfo.Thread)
+		windows.CloseHandle(procInfo.Process)
+		return nil, err
+	}
+	return j, nil
+}
+
+// kill ends every process in the job, recording limit if it was killed for one. It does nothing once
+// the job is closed.
+func (j *job) kill(limit string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.handle == 0 {
+		return nil
+	}
+	if j.limit == "" {
+		j.limit = limit
+	}
+	return windows.TerminateJobObject(j.handle, 1)
+}
+
+// exceededLimit returns the limit the job was killed for, if any.
+func (j *job) exceededLimit() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.limit
+}
+
+// close releases the job object and stops enforcing its limits. Processes still in it keep running.
+func (j *job) close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	if j.key != 0 {
+		jobsMu.Lock()
+		delete(jobsByKey, j.key)
+		jobsMu.Unlock()
+	}
+	closeHandle(&j.handle)
+}
+
+// interruptConsole sends Ctrl-C to the processes attached to the console of pid by attaching the
+// server to that console for a moment. It fails if the process has no console or the server has one
+// of its own.
+func interruptConsole(pid int) error {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	if ret, _, err := attachConsole.Call(uintptr(pid)); ret == 0 {
+		return fmt.Errorf("failed to attach to the console of process %d: %v", pid, err)
+	}
+	setConsoleCtrlHandler.Call(0, 1)
+	err := windows.GenerateConsoleCtrlEvent(windows.CTRL_C_EVENT, 0)
+	freeConsole.Call()
+	// The event reaches the server as well, asynchronously, so keep ignoring it for a while.
+	time.Sleep(100 * time.Millisecond)
+	setConsoleCtrlHandler.Call(0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to send Ctrl-C to process %d: %w", pid, err)
+	}
+	return nil
+}
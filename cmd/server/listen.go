@@ -0,0 +1,99 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+var (
+	listenersMu sync.Mutex
+	listeners   = map[string]net.Listener{}
+	accessList  atomic.Pointer[clipd.AccessList]
+)
+
+func listenAddresses(cfg *clipd.Config) []string {
+	var addrs []string
+	for _, l := range cfg.Listeners() {
+		addrs = append(addrs, l.Address)
+	}
+	return addrs
+}
+
+// bindListeners opens a listener for every address that is not bound yet. Nothing changes until the
+// returned commit is called, which starts serving the new listeners and closes the ones no longer wanted.
+// If commit is not going to be called, rollback must be called instead.
+func bindListeners(addrs []string) (commit func(), rollback func(), err error) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	wanted := make(map[string]bool, len(addrs))
+	opened := map[string]net.Listener{}
+	rollback = func() {
+		for _, ln := range opened {
+			ln.Close()
+		}
+	}
+	for _, addr := range addrs {
+		wanted[addr] = true
+		if _, ok := listeners[addr]; ok {
+			continue
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			rollback()
+			return nil, nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		opened[addr] = ln
+	}
+	commit = func() {
+		listenersMu.Lock()
+		defer listenersMu.Unlock()
+		for addr, ln := range listeners {
+			if !wanted[addr] {
+				supervisor.RemoveListener(ln)
+				delete(listeners, addr)
+				log.Printf("Stopped listening on %s", addr)
+			}
+		}
+		for addr, ln := range opened {
+			if !supervisor.AddListener(ln) {
+				continue
+			}
+			listeners[addr] = ln
+			log.Printf("Listening on %s", addr)
+			go serve(ln, addr)
+		}
+	}
+	return commit, rollback, nil
+}
+
+func serve(ln net.Listener, addr string) {
+	handler := func(c net.Conn, activity *clipd.Activity) {
+		handle(c, activity, addr)
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			showErrorBox("Error", fmt.Sprintf("Connection accept error: %v", err))
+			continue
+		}
+		client := remoteHost(conn.RemoteAddr())
+		if !accessList.Load().Permits(client) {
+			metrics.ConnectionRejected()
+			log.Printf("Rejected connection from %s on %s by address list", client, addr)
+			conn.Close()
+			continue
+		}
+		supervisor.Go(conn, client, handler)
+	}
+}
@@ -3,14 +3,19 @@
 package main
 
 import (
-	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -40,14 +45,24 @@ var (
 	cfUnicodeText         = uintptr(13)
 	gmemMoveable          = uintptr(2)
 	mbIconError           = uintptr(0x00000010)
-	server                net.Listener
-	serverCtx             context.Context
-	serverCancel          context.CancelFunc
-	config                *clipd.Config
+	supervisor            = clipd.NewSupervisor()
+	config                atomic.Pointer[clipd.Config]
+	paused                atomic.Bool
+	policy                atomic.Pointer[clipd.Policy]
+	started               = time.Now()
+	auditLog              *clipd.AuditLog
+	metrics               = clipd.NewMetrics()
+	limiter               *clipd.Limiter
+	guard                 *clipd.AuthGuard
+	hooks                 *clipd.Hooks
+	authorizedKeys        atomic.Pointer[clipd.AuthorizedKeys]
+	processes             = clipd.NewProcessTable()
+	jobs                  *clipd.JobStore
 )
 
 const (
 	SEE_MASK_NOCLOSEPROCESS      = 0x00000040
+	SEE_MASK_INVOKEIDLIST        = 0x0000000C
 	SW_SHOWNORMAL                = 1
 	SPI_GETFOREGROUNDLOCKTIMEOUT = 0x2000
 	SPI_SETFOREGROUNDLOCKTIMEOUT = 0x2001
@@ -91,35 +106,67 @@ func main() {
 		showErrorBox("Error", fmt.Sprintf("Failed to load config: %v", err))
 		os.Exit(1)
 	}
-	config = cfg
-	serverCtx, serverCancel = context.WithCancel(context.Background())
-	go startServer(cfg)
-	systray.Run(onReady, onExit)
-}
-
-func startServer(cfg *clipd.Config) {
-	addr := fmt.Sprintf("%s:%d", cfg.ServerIP, cfg.ServerPort)
-	ln, err := net.Listen("tcp", addr)
+	config.Store(cfg)
+	if err := openLogFile(cfg.Server.LogFile); err != nil {
+		showErrorBox("Error", err.Error())
+		os.Exit(1)
+	}
+	limiter = clipd.NewLimiter(cfg.Server.Limits)
+	guard, err = clipd.NewAuthGuard(cfg.Server.Lockout)
 	if err != nil {
-		showErrorBox("Error", fmt.Sprintf("Failed to start server on %s: %v", addr, err))
+		showErrorBox("Error", err.Error())
 		os.Exit(1)
 	}
-	defer ln.Close()
-	tcpLn := ln.(*net.TCPListener)
-	for {
-		tcpLn.SetDeadline(time.Now().Add(1 * time.Second))
-		conn, err := tcpLn.Accept()
-		if serverCtx.Err() != nil {
-			return
-		}
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue
-			}
-			showErrorBox("Error", fmt.Sprintf("Connection accept error: %v", err))
-			continue
-		}
-		go handle(conn)
+	auditLog, err = clipd.OpenAuditLog(cfg.Server.AuditLog)
+	if err != nil {
+		showErrorBox("Error", err.Error())
+		os.Exit(1)
+	}
+	acl, err := clipd.NewAccessList(cfg.Server.Allow, cfg.Server.Deny)
+	if err != nil {
+		showErrorBox("Error", err.Error())
+		os.Exit(1)
+	}
+	accessList.Store(acl)
+	pol, err := loadPolicy(cfg)
+	if err != nil {
+		showErrorBox("Error", err.Error())
+		os.Exit(1)
+	}
+	policy.Store(pol)
+	keys, err := clipd.LoadAuthorizedKeys(cfg.Server.AuthorizedKeys)
+	if err != nil {
+		showErrorBox("Error", err.Error())
+		os.Exit(1)
+	}
+	authorizedKeys.Store(&keys)
+	hooks = clipd.NewHooks(cfg.Server.Hooks)
+	hooks.Logf = log.Printf
+	jobs = clipd.NewJobStore(cfg.Server.Jobs)
+	supervisor.OnPanic = recoverHandler
+	if cfg.Server.MetricsAddress != "" {
+		go startMetricsServer(cfg.Server.MetricsAddress)
+	}
+	commit, _, err := bindListeners(listenAddresses(cfg))
+	if err != nil {
+		showErrorBox("Error", fmt.Sprintf("Failed to start server: %v", err))
+		os.Exit(1)
+	}
+	commit()
+	if path, err := clipd.ConfigPath(); err == nil {
+		go clipd.WatchFiles(func() []string { return watchedFiles(path) }, configPollInterval, nil, func() { reloadConfig() })
+	}
+	systray.Run(onReady, onExit)
+}
+
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.WritePrometheus(w)
+	})
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		showErrorBox("Error", fmt.Sprintf("Failed to start metrics server on %s: %v", addr, err))
 	}
 }
 
@@ -139,42 +186,404 @@ func onReady() {
 }
 
 func onExit() {
-	if serverCancel != nil {
-		serverCancel()
+	interrupted := supervisor.Shutdown(time.Duration(config.Load().Server.ShutdownGrace))
+	for _, req := range interrupted {
+		log.Printf("Shutdown interrupted request %d from %s: %s %s (running for %v)", req.ID, req.Client, req.Type, req.Detail, time.Since(req.Started).Round(time.Millisecond))
 	}
 	os.Exit(0)
 }
 
-func handle(c net.Conn) {
-	defer c.Close()
+func recoverHandler(c net.Conn, req clipd.ActiveRequest, recovered any, stack []byte) {
+	log.Printf("Recovered from panic while handling request from %s: %v\n%s", c.RemoteAddr(), recovered, stack)
+	entry := clipd.AuditEntry{Time: req.Started, Client: req.Client, Type: req.Type, Program: req.Detail}
+	if entry.Type == "" {
+		entry.Type = "unknown"
+	}
+	err := fmt.Errorf("internal server error: %v", recovered)
+	recordRequest(entry, clipd.AuditResultError, err)
+	writeResponse(c, clipd.Response{Status: clipd.StatusError, Error: err.Error()})
+}
+
+type countingConn struct {
+	net.Conn
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	metrics.BytesReceived(n)
+	return n, err
+}
+
+var (
+	errIncorrectPassword = errors.New("incorrect password")
+	errAdminRequired     = errors.New("admin requests require an admin credential or a local connection")
+	errPaused            = errors.New("server is paused and not accepting requests to run programs")
+	errForbidden         = errors.New("forbidden")
+)
+
+// request is a decoded request together with what the server knows about its sender.
+type request struct {
+	*clipd.Request
+	client     string
+	credential *clipd.CredentialConfig
+	activity   *clipd.Activity
+	// conn is the client's connection, watched by requests that block until something happens.
+	conn net.Conn
+	// stream is set by dispatch for requests that exchange frames with the client after the ok response.
+	stream func(*clipd.Stream)
+	// releaseSlot gives back the request's process slot. Once a process is launched it is handed to
+	// the process, which keeps the slot until it exits.
+	releaseSlot func()
+	launched    bool
+}
+
+// handle serves one connection. The supervisor closes c once it returns, after answering a panic.
+func handle(c net.Conn, activity *clipd.Activity, listenAddr string) {
+	metrics.ConnectionOpened()
+	defer metrics.ConnectionClosed()
+	c = countingConn{c}
+	client := remoteHost(c.RemoteAddr())
+	entry := clipd.AuditEntry{Time: time.Now(), Client: client}
+	release, err := limiter.AcquireConnection(client)
+	if err != nil {
+		entry.Type = "unknown"
+		reject(c, entry, clipd.StatusRateLimited, clipd.AuditResultRateLimited, errors.New("too many concurrent connections"))
+		return
+	}
+	defer release()
 	var req clipd.Request
 	decoder := json.NewDecoder(c)
 	if err := decoder.Decode(&req); err != nil {
+		entry.Type = "unknown"
+		recordRequest(entry, clipd.AuditResultMalformed, err)
 		showErrorBox("Clipd Server Error", fmt.Sprintf("Failed to decode request: %v", err))
 		return
 	}
-	if config.Password != req.Password {
-		showErrorBox("Clipd Server Error", "Incorrect password received.")
+	describeRequest(&entry, &req)
+	activity.Describe(entry.Type, entry.Program)
+	if err := limiter.AllowRequest(client); err != nil {
+		reject(c, entry, clipd.StatusRateLimited, clipd.AuditResultRateLimited, errors.New("too many requests"))
 		return
 	}
-	switch req.Type {
+	if err := guard.Check(client); err != nil {
+		reject(c, entry, clipd.StatusLockedOut, clipd.AuditResultLockedOut, err)
+		return
+	}
+	cfg := config.Load()
+	listener, ok := cfg.Listener(listenAddr)
+	if !ok {
+		reject(c, entry, clipd.StatusError, clipd.AuditResultError, errors.New("listener is shutting down"))
+		return
+	}
+	credential, err := authenticate(c, decoder, &req, client, cfg, listener)
+	if err != nil {
+		if errors.Is(err, errIncorrectPassword) {
+			metrics.AuthFailure()
+			hooks.Emit(clipd.Event{Name: clipd.EventAuthFailed, Client: client})
+			if ban, banned := guard.Failure(client); banned {
+				log.Printf("Locked out %s until %s after repeated authentication failures (ban %d)", client, ban.Until.Format(time.RFC3339), ban.Bans)
+			}
+		}
+		reject(c, entry, clipd.StatusUnauthorized, clipd.AuditResultUnauthorized, err)
+		if errors.Is(err, errIncorrectPassword) {
+			showErrorBox("Clipd Server Error", "Incorrect password received.")
+		}
+		return
+	}
+	guard.Success(client)
+	entry.Credential = credential.Name
+	if !listener.Allows(req.Type) {
+		reject(c, entry, clipd.StatusForbidden, clipd.AuditResultForbidden, fmt.Errorf("%s requests are not enabled on %s", req.Type, listenAddr))
+		return
+	}
+	if err := limiter.AllowCredential(credential.Name, credential.RateLimit); err != nil {
+		reject(c, entry, clipd.StatusRateLimited, clipd.AuditResultRateLimited, fmt.Errorf("too many requests for credential %q", credential.Name))
+		return
+	}
+	r := &request{Request: &req, client: client, credential: credential, activity: activity, conn: c}
+	data, err := dispatch(r)
+	var approvalErr *clipd.ApprovalError
+	switch {
+	case errors.Is(err, clipd.ErrRateLimited):
+		reject(c, entry, clipd.StatusRateLimited, clipd.AuditResultRateLimited, errors.New("too many concurrent processes"))
+	case errors.Is(err, errPaused):
+		reject(c, entry, clipd.StatusPaused, clipd.AuditResultPaused, err)
+	case errors.Is(err, errForbidden), errors.As(err, new(*clipd.PolicyError)), errors.As(err, new(*clipd.EnvPolicyError)), errors.As(err, new(*clipd.SandboxError)):
+		log.Printf("Denied request from %s (credential %s): %v", client, credential.Name, err)
+		reject(c, entry, clipd.StatusForbidden, clipd.AuditResultForbidden, err)
+	case errors.As(err, &approvalErr):
+		log.Printf("Request from %s (credential %s) was not approved: %v", client, credential.Name, err)
+		if approvalErr.Outcome == clipd.ApprovalTimedOut {
+			reject(c, entry, clipd.StatusTimedOut, clipd.AuditResultTimedOut, err)
+		} else {
+			reject(c, entry, clipd.StatusDenied, clipd.AuditResultDenied, err)
+		}
+	case err != nil:
+		recordRequest(entry, clipd.AuditResultError, err)
+		writeResponse(c, clipd.Response{Status: clipd.StatusError, Error: err.Error(), Credential: credential.Name})
+		showErrorBox("Error", err.Error())
+	default:
+		recordRequest(entry, clipd.AuditResultOK, nil)
+		writeResponse(c, clipd.Response{Status: clipd.StatusOK, Data: data, Credential: credential.Name})
+		if r.stream != nil {
+			r.stream(clipd.NewStream(c, decoder))
+		}
+	}
+}
+
+// authenticate returns the credential matching the request's password or public key. Admin requests need the
+// admin password or a credential granting admin, or the default credential from the local machine.
+func authenticate(c net.Conn, decoder *json.Decoder, req *clipd.Request, client string, cfg *clipd.Config, listener clipd.ListenerConfig) (*clipd.CredentialConfig, error) {
+	if len(req.PublicKey) > 0 {
+		return authenticateKey(c, decoder, req, client)
+	}
+	if req.Type == clipd.RequestTypeAdmin && cfg.Server.AdminPassword != "" && subtle.ConstantTimeCompare([]byte(req.Password), []byte(cfg.Server.AdminPassword)) == 1 {
+		return &clipd.CredentialConfig{Name: clipd.AdminCredentialName, Allow: []string{clipd.RequestTypeAdmin.String()}}, nil
+	}
+	credential, ok := cfg.Authenticate(req.Password, listener)
+	if !ok {
+		return nil, errIncorrectPassword
+	}
+	if req.Type == clipd.RequestTypeAdmin && !credential.IsAdmin() && !isLoopback(client) {
+		return nil, errAdminRequired
+	}
+	return credential, nil
+}
+
+// authenticateKey checks the request's public key against the authorized keys file and has the
+// client sign a fresh challenge to prove it holds the private key.
+func authenticateKey(c net.Conn, decoder *json.Decoder, req *clipd.Request, client string) (*clipd.CredentialConfig, error) {
+	key, ok := authorizedKeys.Load().Find(req.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown public key", errIncorrectPassword)
+	}
+	if len(key.From) > 0 && !key.From.Contains(client) {
+		return nil, fmt.Errorf("%w: key %s may not be used from %s", errIncorrectPassword, clipd.Fingerprint(key.Key), client)
+	}
+	challenge, err := clipd.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(c).Encode(clipd.Challenge{Challenge: challenge}); err != nil {
+		return nil, fmt.Errorf("failed to send challenge: %w", err)
+	}
+	var proof clipd.Proof
+	if err := decoder.Decode(&proof); err != nil {
+		return nil, fmt.Errorf("failed to read challenge response: %w", err)
+	}
+	if !clipd.VerifyChallenge(key.Key, challenge, proof.Signature) {
+		return nil, fmt.Errorf("%w: invalid signature for key %s", errIncorrectPassword, clipd.Fingerprint(key.Key))
+	}
+	return key.Credential(), nil
+}
+
+func reject(c net.Conn, entry clipd.AuditEntry, status clipd.ResponseStatus, result string, err error) {
+	recordRequest(entry, result, err)
+	writeResponse(c, clipd.Response{Status: status, Error: err.Error(), Credential: entry.Credential})
+}
+
+// authorizeProgram applies the credential's program list and the server policy, including the environment
+// variables it protects, to a request starting a program.
+func authorizeProgram(r *request) error {
+	program := policyProgram(r.Data)
+	if !r.credential.AllowsProgram(program) {
+		return fmt.Errorf("%w: credential %q may not run %s", errForbidden, r.credential.Name, program)
+	}
+	if err := clipd.ValidateEnv(r.Env); err != nil {
+		return err
+	}
+	if err := policy.Load().CheckEnv(r.Env); err != nil {
+		return err
+	}
+	decision, err := policy.Load().Check(program, r.Args, r.WorkingDir, r.Verb)
+	if err != nil {
+		return err
+	}
+	if decision.RequireApproval {
+		return approve(r, decision)
+	}
+	return nil
+}
+
+func dispatch(r *request) (json.RawMessage, error) {
+	if !r.credential.Allows(r.Type) {
+		return nil, fmt.Errorf("%w: credential %q may not make %s requests", errForbidden, r.credential.Name, r.Type)
+	}
+	if r.Type.IsProcess() {
+		if paused.Load() {
+			return nil, errPaused
+		}
+		workingDir, err := config.Load().Server.Sandbox.Check(r.Data, r.WorkingDir, r.Args)
+		if err != nil {
+			return nil, err
+		}
+		r.WorkingDir = workingDir
+		if err := authorizeProgram(r); err != nil {
+			return nil, err
+		}
+		release, err := limiter.AcquireProcess(r.client)
+		if err != nil {
+			return nil, err
+		}
+		// A launched process keeps the slot until it exits. Otherwise streaming requests keep it until
+		// the stream ends and others give it back now.
+		r.releaseSlot = release
+		defer func() {
+			if stream := r.stream; stream != nil {
+				r.stream = func(s *clipd.Stream) {
+					defer r.releaseUnlaunched()
+					stream(s)
+				}
+			} else {
+				r.releaseUnlaunched()
+			}
+		}()
+	}
+	switch r.Type {
 	case clipd.RequestTypeClipboard:
-		if err := setClipboard(req.Data); err != nil {
-			showErrorBox("Error", fmt.Sprintf("Clipboard operation failed: %v", err))
+		if err := setClipboard(r.Data); err != nil {
+			return nil, fmt.Errorf("Clipboard operation failed: %v", err)
 		}
+		hooks.Emit(clipd.Event{Name: clipd.EventClipboardReceived, Client: r.client, Credential: r.credential.Name, Content: r.Data, ContentSize: len(r.Data)})
 	case clipd.RequestTypeRun:
-		if err := runProgram(req.Data, req.Args, req.WorkingDir); err != nil {
-			showErrorBox("Error", fmt.Sprintf("Program execution failed: %v", err))
+		spec, err := r.ShellSpec()
+		if err != nil {
+			return nil, err
+		}
+		if spec.Verb != "" && (r.Detach || processEnvironment(r) != nil) {
+			return nil, fmt.Errorf("verbs need the shell, which cannot start detached jobs or set the environment")
+		}
+		if r.Detach {
+			return startJob(r)
+		}
+		var id int
+		if env := processEnvironment(r); env != nil {
+			// ShellExecuteEx cannot be given an environment, so start the program directly.
+			process, err := startProcess(r.Data, r.Args, r.WorkingDir, processOptions{env: env, limits: processLimits(r)})
+			if err != nil {
+				return nil, fmt.Errorf("Program execution failed: %v", err)
+			}
+			launched := processLaunched(r, process.PID(), process.Kill)
+			waitForExit(process, launched)
+			id = launched.info.ID
+		} else {
+			process, err := shellLauncher.ShellExecute(spec)
+			if err != nil {
+				return nil, fmt.Errorf("Program execution failed: %v", err)
+			}
+			id = watchProcess(windows.Handle(process), r)
+		}
+		if r.Wait {
+			return waitForProgram(r, id)
+		}
+	case clipd.RequestTypeEdit:
+		if err := startEdit(r); err != nil {
+			return nil, err
 		}
 	case clipd.RequestTypePipe:
-		if err := runProgramWithInput(req.Data, req.Args, req.WorkingDir, req.Stdin); err != nil {
-			showErrorBox("Error", fmt.Sprintf("Program pipe execution failed: %v", err))
+		if r.StreamStdin {
+			process, err := startProcess(r.Data, r.Args, r.WorkingDir, processOptions{stdin: true, env: processEnvironment(r), limits: processLimits(r)})
+			if err != nil {
+				return nil, fmt.Errorf("Program pipe execution failed: %v", err)
+			}
+			r.stream = func(stream *clipd.Stream) { streamInput(stream, process, r) }
+			return nil, nil
+		}
+		process, err := runProgramWithInput(r.Data, r.Args, r.WorkingDir, processEnvironment(r), processLimits(r), r.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("Program pipe execution failed: %v", err)
+		}
+		waitForExit(process, processLaunched(r, process.PID(), process.Kill))
+	case clipd.RequestTypeExec, clipd.RequestTypeShell:
+		spec := r.LaunchSpec()
+		spec.Env = processEnvironment(r)
+		spec.Limits = processLimits(r)
+		process, err := launcher.Launch(spec)
+		if err != nil {
+			return nil, fmt.Errorf("Program execution failed: %v", err)
+		}
+		r.stream = func(stream *clipd.Stream) { streamProcess(stream, process, r) }
+	case clipd.RequestTypeAudit:
+		var filter clipd.AuditFilter
+		if r.AuditFilter != nil {
+			filter = *r.AuditFilter
+		}
+		entries, err := auditLog.Query(filter)
+		if err != nil {
+			return nil, fmt.Errorf("Audit query failed: %v", err)
 		}
+		return json.Marshal(entries)
+	case clipd.RequestTypeStats:
+		return json.Marshal(metrics.Snapshot())
+	case clipd.RequestTypeAdmin:
+		return handleAdmin(r)
+	case clipd.RequestTypeProcess:
+		return handleProcess(r)
+	case clipd.RequestTypeJob:
+		return handleJob(r)
+	case clipd.RequestTypePolicyTest:
+		return json.Marshal(policy.Load().Evaluate(policyProgram(r.Data), r.Args, r.WorkingDir, r.Verb))
 	default:
-		showErrorBox("Error", fmt.Sprintf("Unknown request type: %v", req.Type))
+		return nil, fmt.Errorf("Unknown request type: %v", r.Type)
+	}
+	return nil, nil
+}
+
+func writeResponse(c net.Conn, resp clipd.Response) {
+	if err := json.NewEncoder(c).Encode(resp); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func describeRequest(entry *clipd.AuditEntry, req *clipd.Request) {
+	entry.Type = req.Type.String()
+	switch req.Type {
+	case clipd.RequestTypeClipboard:
+		entry.ContentHash = clipd.HashContent(req.Data)
+		entry.ContentSize = len(req.Data)
+	case clipd.RequestTypeRun, clipd.RequestTypePipe, clipd.RequestTypeExec, clipd.RequestTypeShell, clipd.RequestTypePolicyTest:
+		entry.Program = req.Data
+		entry.Verb = req.Verb
+		entry.Args = req.Args
+		entry.WorkingDir = req.WorkingDir
+		entry.ContentHash = clipd.HashContent(req.Stdin)
+		entry.ContentSize = len(req.Stdin)
+	case clipd.RequestTypeEdit:
+		entry.Program = req.Data
+		entry.Args = append(slices.Clone(req.Args), req.FileName)
+		entry.ContentHash = clipd.HashContent(string(req.File))
+		entry.ContentSize = len(req.File)
+	case clipd.RequestTypeAdmin, clipd.RequestTypeProcess, clipd.RequestTypeJob:
+		entry.Args = append([]string{req.Data}, req.Args...)
+	}
+}
+
+func recordRequest(entry clipd.AuditEntry, result string, err error) {
+	entry.Result = result
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	duration := time.Since(entry.Time)
+	entry.DurationMs = duration.Milliseconds()
+	metrics.RequestDone(entry.Type, result, duration)
+	if err := auditLog.Append(entry); err != nil {
+		log.Printf("Failed to write audit entry: %v", err)
 	}
 }
 
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func remoteHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
 func setClipboard(s string) error {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
@@ -200,112 +609,266 @@ func setClipboard(s string) error {
 	return nil
 }
 
-func runProgram(program string, args []string, workingDir string) error {
-	lpFile, err := clipd.ToUTF16Ptr(program, "program path")
+// shellExecutor opens the files of run and edit requests with ShellExecuteEx.
+type shellExecutor struct{}
+
+var shellLauncher clipd.ShellLauncher = shellExecutor{}
+
+func (shellExecutor) ShellExecute(spec clipd.ShellSpec) (uintptr, error) {
+	process, err := runProgram(spec)
+	return uintptr(process), err
+}
+
+// runProgram launches spec's file through the shell. The returned process handle, which is zero when
+// the shell did not start a new process, must be closed by the caller.
+func runProgram(spec clipd.ShellSpec) (windows.Handle, error) {
+	lpFile, err := clipd.ToUTF16Ptr(spec.File, "program path")
 	if err != nil {
-		return err
+		return 0, err
 	}
-	lpParameters, err := clipd.OptionalUTF16Ptr(buildArgsString(args), "parameters")
+	lpParameters, err := clipd.OptionalUTF16Ptr(buildArgsString(spec.Args), "parameters")
 	if err != nil {
-		return err
+		return 0, err
 	}
-	lpDirectory, err := clipd.OptionalUTF16Ptr(workingDir, "working directory")
+	lpDirectory, err := clipd.OptionalUTF16Ptr(spec.WorkingDir, "working directory")
 	if err != nil {
-		return err
+		return 0, err
+	}
+	lpVerb, err := clipd.OptionalUTF16Ptr(spec.Verb, "verb")
+	if err != nil {
+		return 0, err
 	}
 	sei := SHELLEXECUTEINFO{
 		cbSize:       uint32(unsafe.Sizeof(SHELLEXECUTEINFO{})),
 		fMask:        SEE_MASK_NOCLOSEPROCESS,
+		lpVerb:       lpVerb,
 		lpFile:       lpFile,
 		lpParameters: lpParameters,
 		lpDirectory:  lpDirectory,
 		nShow:        SW_SHOWNORMAL,
 	}
+	if spec.InvokeIDList {
+		sei.fMask |= SEE_MASK_INVOKEIDLIST
+	}
 	var oldTimeout uintptr
 	systemParametersInfoW.Call(SPI_GETFOREGROUNDLOCKTIMEOUT, 0, uintptr(unsafe.Pointer(&oldTimeout)), 0)
 	systemParametersInfoW.Call(SPI_SETFOREGROUNDLOCKTIMEOUT, 0, 0, 0)
+	consoleMu.Lock()
 	ret, _, err := shellExecuteExW.Call(uintptr(unsafe.Pointer(&sei)))
+	consoleMu.Unlock()
 	if ret == 0 {
 		systemParametersInfoW.Call(SPI_SETFOREGROUNDLOCKTIMEOUT, 0, oldTimeout, 0)
-		return fmt.Errorf("ShellExecuteEx failed: %v", err)
+		return 0, fmt.Errorf("ShellExecuteEx failed: %v", err)
 	}
-	defer func() {
-		if sei.hProcess != 0 {
-			windows.CloseHandle(windows.Handle(sei.hProcess))
-		}
-	}()
+	metrics.ProcessSpawned()
 	waitForInputIdle.Call(uintptr(sei.hProcess), 5000)
 	systemParametersInfoW.Call(SPI_SETFOREGROUNDLOCKTIMEOUT, 0, oldTimeout, 0)
-	return nil
+	return windows.Handle(sei.hProcess), nil
 }
 
-func runProgramWithInput(program string, args []string, workingDir, stdinData string) error {
-	resolvedProgram, err := resolveExecutable(program)
+// runProgramWithInput starts program with stdinData on its standard input. The caller must Wait for
+// the returned process to release it.
+func runProgramWithInput(program string, args []string, workingDir string, env []string, limits clipd.ProcessLimits, stdinData string) (*pipeProcess, error) {
+	process, err := startProcess(program, args, workingDir, processOptions{stdin: true, env: env, limits: limits})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	lpFile, err := clipd.ToUTF16Ptr(resolvedProgram, "program path")
-	if err != nil {
-		return err
+	if err := writeToHandle(process.stdin.handle, []byte(stdinData)); err != nil {
+		process.release()
+		return nil, fmt.Errorf("failed to write stdin: %w", err)
 	}
-	lpDirectory, err := clipd.OptionalUTF16Ptr(workingDir, "working directory")
-	if err != nil {
-		return err
+	process.stdin.Close()
+	return process, nil
+}
+
+// processEnvironment returns the environment for the program of r, or nil to inherit the server's. A
+// clean environment still keeps SystemRoot, which most programs need to start, and the variables the
+// policy protects.
+func processEnvironment(r *request) []string {
+	if len(r.Env) == 0 && !r.CleanEnv {
+		return nil
 	}
-	commandLine := buildCommandLine(resolvedProgram, args)
-	cmdLine, err := windows.UTF16FromString(commandLine)
-	if err != nil {
-		return fmt.Errorf("failed to build command line: %w", err)
+	current := policy.Load()
+	return clipd.MergeEnvironment(os.Environ(), r.Env, r.CleanEnv, func(name string) bool {
+		return strings.EqualFold(name, "SystemRoot") || current.ProtectsEnv(name)
+	})
+}
+
+func processEvent(r *request, pid int) clipd.Event {
+	return clipd.Event{
+		Name:       clipd.EventProcessLaunched,
+		Client:     r.client,
+		Credential: r.credential.Name,
+		Program:    r.Data,
+		Args:       r.Args,
+		WorkingDir: r.WorkingDir,
+		PID:        pid,
 	}
-	sa := inheritableSA()
-	var readPipe, writePipe windows.Handle
-	if err := windows.CreatePipe(&readPipe, &writePipe, &sa, 0); err != nil {
-		return fmt.Errorf("failed to create pipe: %w", err)
+}
+
+// releaseUnlaunched gives back the request's process slot if no process took it over.
+func (r *request) releaseUnlaunched() {
+	if !r.launched {
+		r.releaseSlot()
 	}
-	defer closeHandle(&readPipe)
-	defer closeHandle(&writePipe)
-	if err := windows.SetHandleInformation(writePipe, windows.HANDLE_FLAG_INHERIT, 0); err != nil {
-		return fmt.Errorf("failed to configure pipe handle: %w", err)
+}
+
+// launchedProcess is a program started for a request, as reported to hooks and the process table.
+type launchedProcess struct {
+	event clipd.Event
+	info  clipd.ProcessInfo
+	// release gives back the process slot of the request that started it.
+	release func()
+}
+
+// processLaunched reports the launch of process pid, which kill stops, and adds it to the process table.
+func processLaunched(r *request, pid int, kill func() error) launchedProcess {
+	event := processEvent(r, pid)
+	hooks.Emit(event)
+	info := clipd.ProcessInfo{
+		PID:        pid,
+		Type:       r.Type.String(),
+		Program:    r.Data,
+		Args:       r.Args,
+		Client:     r.client,
+		Credential: r.credential.Name,
+		Started:    time.Now(),
+	}
+	info.ID = processes.Add(info, kill)
+	r.launched = true
+	return launchedProcess{event: event, info: info, release: r.releaseSlot}
+}
+
+func (p launchedProcess) exited(status clipd.ExitStatus) {
+	p.release()
+	processes.Exited(p.info.ID, status)
+	emitProcessExited(p.event, status)
+}
+
+// lost marks the process as exited when its exit code could not be read, so waiting clients are not
+// left hanging.
+func (p launchedProcess) lost() {
+	p.release()
+	processes.Exited(p.info.ID, clipd.ExitStatus{Code: -1})
+}
+
+func emitProcessExited(event clipd.Event, status clipd.ExitStatus) {
+	if status.Reason != "" {
+		log.Printf("Process %d (%s) for %s %s", event.PID, event.Program, event.Client, status)
+	}
+	event.Name = clipd.EventProcessExited
+	event.Time = time.Time{}
+	event.ExitCode = &status.Code
+	event.Reason = status.Reason
+	hooks.Emit(event)
+}
+
+// processLimits returns the limits for the program of r.
+func processLimits(r *request) clipd.ProcessLimits {
+	return config.Load().Server.Limits.ProcessLimits(time.Duration(r.Timeout))
+}
+
+// watchProcess reports the launch of process and waits in the background for it to exit,
+// closing its handle afterwards. It returns the process's ID in the process table, or 0 if the shell
+// gave no process to watch.
+func watchProcess(process windows.Handle, r *request) int {
+	if process == 0 {
+		hooks.Emit(processEvent(r, 0))
+		return 0
+	}
+	pid, _ := windows.GetProcessId(process)
+	// Programs started through the shell only join a job once running, so processes they start
+	// straight away may escape their limits.
+	var limited *job
+	if limits := processLimits(r); limits != (clipd.ProcessLimits{}) {
+		var err error
+		if limited, err = newJob(limits); err == nil {
+			if err = limited.assign(process); err != nil {
+				limited.close()
+			}
+		}
+		if err != nil {
+			log.Printf("Terminating process %d, which could not be limited: %v", pid, err)
+			windows.TerminateProcess(process, 1)
+			limited = nil
+		}
 	}
-	stdoutHandle, err := openNullHandle(&sa)
-	if err != nil {
-		return fmt.Errorf("failed to open NUL for stdout: %w", err)
+	// The handle stays open until the process is marked exited, so killing it cannot hit a reused handle.
+	kill := func() error { return windows.TerminateProcess(process, 1) }
+	if limited != nil {
+		kill = func() error { return limited.kill("") }
 	}
-	defer closeHandle(&stdoutHandle)
-	stderrHandle, err := openNullHandle(&sa)
+	launched := processLaunched(r, int(pid), kill)
+	go func() {
+		defer windows.CloseHandle(process)
+		if limited != nil {
+			defer limited.close()
+		}
+		if _, err := windows.WaitForSingleObject(process, windows.INFINITE); err != nil {
+			log.Printf("Failed to wait for process %d: %v", pid, err)
+			launched.lost()
+			return
+		}
+		var code uint32
+		if err := windows.GetExitCodeProcess(process, &code); err != nil {
+			log.Printf("Failed to get exit code of process %d: %v", pid, err)
+			launched.lost()
+			return
+		}
+		status := clipd.ExitStatus{Code: int(code)}
+		if limited != nil {
+			status.Reason = limited.exceededLimit()
+		}
+		launched.exited(status)
+	}()
+	return launched.info.ID
+}
+
+// waitForExit waits in the background for process to exit and reports it.
+func waitForExit(process clipd.Process, launched launchedProcess) {
+	go func() {
+		code, err := process.Wait()
+		if err != nil {
+			log.Printf("Failed to wait for process %d: %v", process.PID(), err)
+			launched.lost()
+			return
+		}
+		status := clipd.ExitStatus{Code: code}
+		if reporter, ok := process.(clipd.LimitReporter); ok {
+			status.Reason = reporter.ExceededLimit()
+		}
+		launched.exited(status)
+	}()
+}
+
+// streamInput feeds a pipe request's process the stdin the client streams. The process keeps running
+// after its input ends, like with buffered pipe requests, unless the client stopped it.
+func streamInput(stream *clipd.Stream, process *pipeProcess, r *request) {
+	launched := processLaunched(r, process.PID(), process.Kill)
+	status, err := clipd.ServeInput(stream, process, serveOptions())
 	if err != nil {
-		return fmt.Errorf("failed to open NUL for stderr: %w", err)
+		log.Printf("Pipe to %s for %s: %v", r.Data, r.client, err)
 	}
-	defer closeHandle(&stderrHandle)
-	startupInfo := &windows.StartupInfo{
-		Cb:        uint32(unsafe.Sizeof(windows.StartupInfo{})),
-		Flags:     windows.STARTF_USESTDHANDLES,
-		StdInput:  readPipe,
-		StdOutput: stdoutHandle,
-		StdErr:    stderrHandle,
+	if status == nil {
+		waitForExit(process, launched)
+		return
 	}
-	var procInfo windows.ProcessInformation
-	var oldTimeout uintptr
-	systemParametersInfoW.Call(SPI_GETFOREGROUNDLOCKTIMEOUT, 0, uintptr(unsafe.Pointer(&oldTimeout)), 0)
-	systemParametersInfoW.Call(SPI_SETFOREGROUNDLOCKTIMEOUT, 0, 0, 0)
-	err = windows.CreateProcess(lpFile, &cmdLine[0], nil, nil, true, 0, nil, lpDirectory, startupInfo, &procInfo)
+	launched.exited(*status)
+}
+
+// streamProcess connects an exec or shell request's process to the client until it exits.
+func streamProcess(stream *clipd.Stream, process clipd.Process, r *request) {
+	launched := processLaunched(r, process.PID(), process.Kill)
+	status, err := clipd.ServeProcess(stream, process, serveOptions())
 	if err != nil {
-		systemParametersInfoW.Call(SPI_SETFOREGROUNDLOCKTIMEOUT, 0, oldTimeout, 0)
-		return fmt.Errorf("CreateProcess failed: %w", err)
+		log.Printf("%s of %s for %s: %v", r.Type, r.Data, r.client, err)
 	}
-	windows.CloseHandle(procInfo.Thread)
-	defer windows.CloseHandle(procInfo.Process)
-	windows.CloseHandle(readPipe)
-	readPipe = 0
-	if err := writeToHandle(writePipe, []byte(stdinData)); err != nil {
-		systemParametersInfoW.Call(SPI_SETFOREGROUNDLOCKTIMEOUT, 0, oldTimeout, 0)
-		return fmt.Errorf("failed to write stdin: %w", err)
-	}
-	windows.CloseHandle(writePipe)
-	writePipe = 0
-	waitForInputIdle.Call(uintptr(procInfo.Process), 5000)
-	systemParametersInfoW.Call(SPI_SETFOREGROUNDLOCKTIMEOUT, 0, oldTimeout, 0)
-	return nil
+	launched.exited(status)
+}
+
+func serveOptions() clipd.ServeOptions {
+	server := config.Load().Server
+	return clipd.ServeOptions{KillGrace: time.Duration(server.KillGrace), MaxOutput: server.Limits.MaxOutputBytes}
 }
 
 func writeToHandle(handle windows.Handle, data []byte) error {
@@ -363,6 +926,15 @@ func resolveExecutable(program string) (string, error) {
 	return resolved, nil
 }
 
+// policyProgram resolves program the way it will be launched so policy rules can match its full path.
+// Programs that are not found on PATH, such as documents opened by the shell, are matched as given.
+func policyProgram(program string) string {
+	if resolved, err := resolveExecutable(program); err == nil {
+		return resolved
+	}
+	return program
+}
+
 func quoteArgument(arg string) string {
 	return syscall.EscapeArg(arg)
 }
@@ -374,10 +946,10 @@ func inheritableSA() windows.SecurityAttributes {
 	}
 }
 
-func openNullHandle(sa *windows.SecurityAttributes) (windows.Handle, error) {
+func openNullHandle(sa *windows.SecurityAttributes, access uint32) (windows.Handle, error) {
 	handle, err := windows.CreateFile(
 		windows.StringToUTF16Ptr("NUL"),
-		windows.GENERIC_WRITE,
+		access,
 		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
 		sa,
 		windows.OPEN_EXISTING,
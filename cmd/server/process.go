@@ -0,0 +1,272 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+// processOptions chooses which standard streams of a started process are connected to pipes.
+type processOptions struct {
+	// stdin connects stdin to a pipe rather than NUL.
+	stdin bool
+	// output connects stdout and stderr to pipes and starts the program without a console window.
+	// Without it output goes to NUL and the program is brought to the foreground like run requests.
+	output bool
+	// env is the environment of the process. Nil inherits the server's.
+	env    []string
+	limits clipd.ProcessLimits
+}
+
+// pipeProcess is a program whose standard streams are connected to pipes held by the server.
+type pipeProcess struct {
+	mu     sync.Mutex
+	handle windows.Handle
+	pid    int
+	stdin  *handleWriter
+	stdout *os.File
+	stderr *os.File
+	job    *job
+}
+
+func startProcess(program string, args []string, workingDir string, options processOptions) (*pipeProcess, error) {
+	resolvedProgram, err := resolveExecutable(program)
+	if err != nil {
+		return nil, err
+	}
+	lpFile, err := clipd.ToUTF16Ptr(resolvedProgram, "program path")
+	if err != nil {
+		return nil, err
+	}
+	lpDirectory, err := clipd.OptionalUTF16Ptr(workingDir, "working directory")
+	if err != nil {
+		return nil, err
+	}
+	cmdLine, err := windows.UTF16FromString(buildCommandLine(resolvedProgram, args))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build command line: %w", err)
+	}
+	sa := inheritableSA()
+	// Handles ending up in parent are the server's ends of the pipes; child handles are closed once
+	// the process has inherited them.
+	var parent, child []windows.Handle
+	defer func() {
+		for i := range child {
+			closeHandle(&child[i])
+		}
+		for i := range parent {
+			closeHandle(&parent[i])
+		}
+	}()
+	var stdinRead, stdinWrite windows.Handle
+	if options.stdin {
+		if err := windows.CreatePipe(&stdinRead, &stdinWrite, &sa, 0); err != nil {
+			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+		parent, child = append(parent, stdinWrite), append(child, stdinRead)
+		if err := windows.SetHandleInformation(stdinWrite, windows.HANDLE_FLAG_INHERIT, 0); err != nil {
+			return nil, fmt.Errorf("failed to configure pipe handle: %w", err)
+		}
+	} else {
+		if stdinRead, err = openNullHandle(&sa, windows.GENERIC_READ); err != nil {
+			return nil, fmt.Errorf("failed to open NUL for stdin: %w", err)
+		}
+		child = append(child, stdinRead)
+	}
+	var stdoutRead, stdoutWrite, stderrRead, stderrWrite windows.Handle
+	if options.output {
+		if stdoutRead, stdoutWrite, err = outputPipe(&sa); err != nil {
+			return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+		}
+		parent, child = append(parent, stdoutRead), append(child, stdoutWrite)
+		if stderrRead, stderrWrite, err = outputPipe(&sa); err != nil {
+			return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+		}
+		parent, child = append(parent, stderrRead), append(child, stderrWrite)
+	} else {
+		if stdoutWrite, err = openNullHandle(&sa, windows.GENERIC_WRITE); err != nil {
+			return nil, fmt.Errorf("failed to open NUL for stdout: %w", err)
+		}
+		child = append(child, stdoutWrite)
+		if stderrWrite, err = openNullHandle(&sa, windows.GENERIC_WRITE); err != nil {
+			return nil, fmt.Errorf("failed to open NUL for stderr: %w", err)
+		}
+		child = append(child, stderrWrite)
+	}
+	startupInfo := &windows.StartupInfo{
+		Cb:        uint32(unsafe.Sizeof(windows.StartupInfo{})),
+		Flags:     windows.STARTF_USESTDHANDLES,
+		StdInput:  stdinRead,
+		StdOutput: stdoutWrite,
+		StdErr:    stderrWrite,
+	}
+	env, err := environmentBlock(options.env)
+	if err != nil {
+		return nil, err
+	}
+	// The process starts suspended so that it is in its job before it can start any children.
+	flags := uint32(windows.CREATE_SUSPENDED | windows.CREATE_UNICODE_ENVIRONMENT)
+	if options.output {
+		flags |= windows.CREATE_NO_WINDOW
+	} else {
+		var oldTimeout uintptr
+		systemParametersInfoW.Call(SPI_GETFOREGROUNDLOCKTIMEOUT, 0, uintptr(unsafe.Pointer(&oldTimeout)), 0)
+		systemParametersInfoW.Call(SPI_SETFOREGROUNDLOCKTIMEOUT, 0, 0, 0)
+		defer systemParametersInfoW.Call(SPI_SETFOREGROUNDLOCKTIMEOUT, 0, oldTimeout, 0)
+	}
+	var procInfo windows.ProcessInformation
+	consoleMu.Lock()
+	err = windows.CreateProcess(lpFile, &cmdLine[0], nil, nil, true, flags, env, lpDirectory, startupInfo, &procInfo)
+	consoleMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("CreateProcess failed: %w", err)
+	}
+	metrics.ProcessSpawned()
+	job, err := resumeInJob(&procInfo, options.limits)
+	if err != nil {
+		return nil, err
+	}
+	windows.CloseHandle(procInfo.Thread)
+	if !options.output {
+		waitForInputIdle.Call(uintptr(procInfo.Process), 5000)
+	}
+	p := &pipeProcess{handle: procInfo.Process, pid: int(procInfo.ProcessId), job: job}
+	if options.stdin {
+		p.stdin = &handleWriter{handle: stdinWrite}
+	}
+	if options.output {
+		p.stdout = os.NewFile(uintptr(stdoutRead), "stdout")
+		p.stderr = os.NewFile(uintptr(stderrRead), "stderr")
+	}
+	parent = nil
+	return p, nil
+}
+
+// environmentBlock encodes env for CreateProcess with CREATE_UNICODE_ENVIRONMENT. A nil env gives a
+// nil block, which inherits the server's environment.
+func environmentBlock(env []string) (*uint16, error) {
+	if env == nil {
+		return nil, nil
+	}
+	var block []uint16
+	for _, entry := range env {
+		encoded, err := windows.UTF16FromString(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid environment variable: %w", err)
+		}
+		block = append(block, encoded...)
+	}
+	// The block ends with an empty string, and is never shorter than two NULs.
+	block = append(block, 0)
+	if len(env) == 0 {
+		block = append(block, 0)
+	}
+	return &block[0], nil
+}
+
+// outputPipe creates a pipe whose write end is inherited by the child and whose read end stays with the server.
+func outputPipe(sa *windows.SecurityAttributes) (windows.Handle, windows.Handle, error) {
+	var readPipe, writePipe windows.Handle
+	if err := windows.CreatePipe(&readPipe, &writePipe, sa, 0); err != nil {
+		return 0, 0, err
+	}
+	if err := windows.SetHandleInformation(readPipe, windows.HANDLE_FLAG_INHERIT, 0); err != nil {
+		windows.CloseHandle(readPipe)
+		windows.CloseHandle(writePipe)
+		return 0, 0, err
+	}
+	return readPipe, writePipe, nil
+}
+
+// handleWriter writes to a pipe handle with writeToHandle, blocking while the pipe is full.
+type handleWriter struct {
+	mu     sync.Mutex
+	handle windows.Handle
+}
+
+func (w *handleWriter) Write(data []byte) (int, error) {
+	if err := writeToHandle(w.handle, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *handleWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	closeHandle(&w.handle)
+	return nil
+}
+
+func (p *pipeProcess) PID() int {
+	return p.pid
+}
+
+func (p *pipeProcess) Stdin() io.WriteCloser {
+	if p.stdin == nil {
+		return nil
+	}
+	return p.stdin
+}
+
+func (p *pipeProcess) Stdout() io.Reader {
+	if p.stdout == nil {
+		return nil
+	}
+	return p.stdout
+}
+
+func (p *pipeProcess) Stderr() io.Reader {
+	if p.stderr == nil {
+		return nil
+	}
+	return p.stderr
+}
+
+// Wait waits for the process to exit and releases its handles.
+func (p *pipeProcess) Wait() (int, error) {
+	if _, err := windows.WaitForSingleObject(p.handle, windows.INFINITE); err != nil {
+		return 0, err
+	}
+	var code uint32
+	err := windows.GetExitCodeProcess(p.handle, &code)
+	p.release()
+	return int(code), err
+}
+
+// release closes the server's handles to the process, its job and its pipes.
+func (p *pipeProcess) release() {
+	p.mu.Lock()
+	closeHandle(&p.handle)
+	p.mu.Unlock()
+	p.job.close()
+	if p.stdin != nil {
+		p.stdin.Close()
+	}
+	if p.stdout != nil {
+		p.stdout.Close()
+		p.stderr.Close()
+	}
+}
+
+// Kill terminates the process and everything it started.
+func (p *pipeProcess) Kill() error {
+	return p.job.kill("")
+}
+
+func (p *pipeProcess) ExceededLimit() string {
+	return p.job.exceededLimit()
+}
+
+// Interrupt sends Ctrl-C to the process's console.
+func (p *pipeProcess) Interrupt() error {
+	return interruptConsole(p.pid)
+}
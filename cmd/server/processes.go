@@ -0,0 +1,60 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+// handleProcess lists, kills and waits for the processes in the process table. Clients see the
+// processes their credential started; admin credentials see all of them.
+func handleProcess(req *request) (json.RawMessage, error) {
+	owner := req.credential.Name
+	if req.credential.IsAdmin() {
+		owner = ""
+	}
+	if req.Data == clipd.ProcessVerbList {
+		return json.Marshal(processes.List(owner))
+	}
+	if len(req.Args) != 1 {
+		return nil, fmt.Errorf("%s needs a process ID", req.Data)
+	}
+	id, err := strconv.Atoi(req.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid process ID %q", req.Args[0])
+	}
+	switch req.Data {
+	case clipd.ProcessVerbKill:
+		info, err := processes.Kill(id, owner)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Killed process %d (%s) for %s (credential %s)", info.PID, info.Program, req.client, req.credential.Name)
+		return json.Marshal(info)
+	case clipd.ProcessVerbWait:
+		info, err := processes.Wait(id, owner, connClosed(req.conn))
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(info)
+	default:
+		return nil, fmt.Errorf("Unknown process verb: %q", req.Data)
+	}
+}
+
+// connClosed returns a channel closed once the client closes conn or the server does. It must only be
+// used once the client has nothing more to send.
+func connClosed(conn net.Conn) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.Read(make([]byte, 1))
+	}()
+	return closed
+}
@@ -0,0 +1,131 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/trypsynth/clipd/clipd"
+)
+
+const configPollInterval = 2 * time.Second
+
+var (
+	logMu    sync.Mutex
+	logFile  *os.File
+	reloadMu sync.Mutex
+)
+
+func openLogFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	setLogFile(file)
+	return nil
+}
+
+func setLogFile(file *os.File) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	log.SetOutput(file)
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = file
+}
+
+func loadPolicy(cfg *clipd.Config) (*clipd.Policy, error) {
+	if cfg.Server.PolicyFile == "" {
+		return nil, nil
+	}
+	return clipd.LoadPolicy(cfg.Server.PolicyFile)
+}
+
+// watchedFiles lists the files whose changes trigger a reload.
+func watchedFiles(configPath string) []string {
+	paths := []string{configPath}
+	cfg := config.Load()
+	if cfg.Server.PolicyFile != "" {
+		paths = append(paths, cfg.Server.PolicyFile)
+	}
+	return append(paths, cfg.Server.AuthorizedKeys)
+}
+
+// reloadConfig loads the config file again and applies it, keeping the current config if it is invalid.
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	cfg, err := clipd.LoadConfig()
+	if err == nil {
+		err = applyConfig(cfg)
+	}
+	if err != nil {
+		log.Printf("Config reload failed, keeping previous config: %v", err)
+		return err
+	}
+	log.Printf("Config reloaded")
+	return nil
+}
+
+// applyConfig switches the server to cfg. Everything that can fail is prepared first so that either
+// the whole config takes effect or none of it does.
+func applyConfig(cfg *clipd.Config) error {
+	old := config.Load()
+	acl, err := clipd.NewAccessList(cfg.Server.Allow, cfg.Server.Deny)
+	if err != nil {
+		return err
+	}
+	pol, err := loadPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	keys, err := clipd.LoadAuthorizedKeys(cfg.Server.AuthorizedKeys)
+	if err != nil {
+		return err
+	}
+	commitListeners, rollbackListeners, err := bindListeners(listenAddresses(cfg))
+	if err != nil {
+		return err
+	}
+	var newLogFile *os.File
+	if cfg.Server.LogFile != old.Server.LogFile {
+		newLogFile, err = os.OpenFile(cfg.Server.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			rollbackListeners()
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+	}
+	if cfg.Server.AuditLog != old.Server.AuditLog {
+		if err := auditLog.Reopen(cfg.Server.AuditLog); err != nil {
+			rollbackListeners()
+			if newLogFile != nil {
+				newLogFile.Close()
+			}
+			return err
+		}
+	}
+	if err := guard.SetConfig(cfg.Server.Lockout); err != nil {
+		// LoadConfig has already validated the lockout settings.
+		log.Printf("Failed to apply lockout settings: %v", err)
+	}
+	if newLogFile != nil {
+		setLogFile(newLogFile)
+	}
+	if cfg.Server.MetricsAddress != old.Server.MetricsAddress {
+		log.Printf("Changing metricsAddress takes effect after a restart")
+	}
+	accessList.Store(acl)
+	policy.Store(pol)
+	authorizedKeys.Store(&keys)
+	limiter.SetLimits(cfg.Server.Limits)
+	hooks.SetHooks(cfg.Server.Hooks)
+	jobs.SetConfig(cfg.Server.Jobs)
+	config.Store(cfg)
+	commitListeners()
+	return nil
+}